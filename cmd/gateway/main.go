@@ -9,14 +9,17 @@ import (
 	"net"
 	"net/http"
 	"nvimanywhere/internal/config"
+	"nvimanywhere/internal/egress"
 	"nvimanywhere/internal/handlers"
 	"nvimanywhere/internal/logging"
+	"nvimanywhere/internal/registry"
 	"nvimanywhere/internal/router"
 	"nvimanywhere/internal/sessions"
 	"nvimanywhere/internal/templates"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 )
 
@@ -35,7 +38,8 @@ func NewHTTPServer(cfg *config.Config, h *handlers.App, log *slog.Logger) *http.
 }
 
 func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	// Docker stops containers with SIGTERM.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	if err := run(ctx); err != nil {
@@ -62,14 +66,45 @@ func run(ctx context.Context) error {
 		return err
 	}
 
-	if err := sessions.Init(cfg); err != nil {
+	runtime, err := sessions.Init(cfg)
+	if err != nil {
 		return err
 	}
-	h := handlers.InitApp(cfg, log, tc, ctx)
+	records, err := registry.Open(cfg.SessionRuntime.Registry)
+	if err != nil {
+		return err
+	}
+	defer records.Close()
+	// Sessions must outlive the signal; Shutdown closes them once drained.
+	h := handlers.InitApp(cfg, log, tc, records, runtime, context.WithoutCancel(ctx))
+	// Take over what a previous run left behind before serving new sessions.
+	if err := h.Reconcile(); err != nil {
+		log.Error("Failed to reconcile sessions", "err", err)
+	}
 
 	srv := NewHTTPServer(cfg, h, log)
 
-	errCh := make(chan error, 1)
+	errCh := make(chan error, 2)
+	if nw := cfg.SessionRuntime.Network; nw.NeedsProxy() {
+		// The proxy has no authentication, so it only listens where
+		// session containers reach it.
+		addr, err := sessions.EgressProxyAddr(ctx, runtime)
+		if err != nil {
+			return err
+		}
+		proxySrv := &http.Server{
+			Addr:              addr,
+			Handler:           egress.New(nw.Proxy.AllowedHosts, log.With("component", "egress")),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		defer proxySrv.Close()
+		go func() {
+			log.Info("Egress proxy started", "addr", addr, "allowed_hosts", nw.Proxy.AllowedHosts)
+			if err := proxySrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errCh <- fmt.Errorf("egress proxy: %w", err)
+			}
+		}()
+	}
 	go func() {
 		log.Info("Server Started")
 		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
@@ -79,19 +114,36 @@ func run(ctx context.Context) error {
 		errCh <- nil
 	}()
 
+	var runErr error
 	select {
 	case <-ctx.Done():
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		if err := srv.Shutdown(shutdownCtx); err != nil {
-			return err
+		log.Info("Shutting down")
+	case runErr = <-errCh:
+		if runErr != nil {
+			log.Error("Server failed, shutting down", "err", runErr)
 		}
-		closeLog()
-		return nil
-	case err := <-errCh:
-		return err
 	}
+	started := time.Now()
+
+	// Sessions are drained first, so clients can still reach the server
+	// while they save; new sessions are refused meanwhile.
+	sd := cfg.SessionRuntime.Shutdown
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), sd.SaveGrace+sd.Timeout)
+	defer cancelDrain()
+	if err := h.Shutdown(drainCtx); err != nil {
+		log.Error("Failed to drain sessions", "err", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && runErr == nil {
+		runErr = err
+	}
+	log.Info("Shutdown complete", "elapsed", time.Since(started).Round(time.Millisecond))
+	closeLog()
+	return runErr
 }
+
 func GetConfigPath(defaultPath string) (string, error) {
 	var cfgFlag string
 	flag.StringVar(&cfgFlag, "config", "", "path to config file (YAML)")
@@ -3,8 +3,11 @@ module nvimanywhere
 go 1.25.1
 
 require (
+	github.com/creack/pty v1.1.24
 	github.com/docker/docker v28.5.1+incompatible
+	github.com/docker/go-units v0.5.0
 	github.com/gorilla/websocket v1.5.3
+	go.etcd.io/bbolt v1.4.3
 	golang.org/x/sync v0.17.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -17,7 +20,6 @@ require (
 	github.com/containerd/log v0.1.0 // indirect
 	github.com/distribution/reference v0.6.0 // indirect
 	github.com/docker/go-connections v0.6.0 // indirect
-	github.com/docker/go-units v0.5.0 // indirect
 	github.com/felixge/httpsnoop v1.0.4 // indirect
 	github.com/go-logr/logr v1.4.3 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
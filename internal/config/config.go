@@ -3,11 +3,15 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/docker/go-units"
 	"gopkg.in/yaml.v3"
 )
 
@@ -23,11 +27,369 @@ type WS struct {
 	PingInterval   time.Duration `yaml:"ping_interval"`
 }
 
+// Supported values of session_runtime.runtime.
+const (
+	RuntimeDocker = "docker"
+	RuntimeLocal  = "local"
+	RuntimePodman = "podman"
+)
+
+// Supported values of session_runtime.registry.backend.
+const (
+	RegistryBolt   = "bolt"
+	RegistryMemory = "memory"
+)
+
+// Podman tunes the podman runtime. Zero values mean auto-detect.
+type Podman struct {
+	// Socket is the API socket path or URL. Empty means discover the
+	// rootless socket first, then the system one.
+	Socket string `yaml:"socket"`
+	// Userns overrides the user namespace mode. Empty means keep-id when
+	// podman runs rootless.
+	Userns string `yaml:"userns"`
+	// UID/GID of the image user that the host user is mapped onto
+	// with keep-id, so the bind mounts are owned by the editor user.
+	UID            int  `yaml:"uid"`
+	GID            int  `yaml:"gid"`
+	SELinuxRelabel bool `yaml:"selinux_relabel"`
+}
+
+// Resources are the limits applied to one session container. Zero values
+// mean "no limit". Memory sizes use docker notation ("512m", "2g").
+type Resources struct {
+	CPUs        float64          `yaml:"cpus" json:"cpus"`
+	Memory      string           `yaml:"memory" json:"memory"`
+	MemorySwap  string           `yaml:"memory_swap" json:"memory_swap"`
+	PidsLimit   int64            `yaml:"pids_limit" json:"pids_limit"`
+	BlkioWeight uint16           `yaml:"blkio_weight" json:"blkio_weight"`
+	Ulimits     map[string]int64 `yaml:"ulimits" json:"ulimits"`
+}
+
+// MemoryBytes returns Memory in bytes, 0 when unset.
+func (r *Resources) MemoryBytes() (int64, error) {
+	return ParseSize(r.Memory)
+}
+
+// MemorySwapBytes returns the memory+swap total in bytes, 0 when unset.
+func (r *Resources) MemorySwapBytes() (int64, error) {
+	return ParseSize(r.MemorySwap)
+}
+
+// Validate checks that every limit is well-formed; field prefixes errors.
+func (r *Resources) Validate(field string) error {
+	if r.CPUs < 0 {
+		return fmt.Errorf("%s.cpus must be >= 0", field)
+	}
+	mem, err := r.MemoryBytes()
+	if err != nil {
+		return fmt.Errorf("%s.memory: %w", field, err)
+	}
+	swap, err := r.MemorySwapBytes()
+	if err != nil {
+		return fmt.Errorf("%s.memory_swap: %w", field, err)
+	}
+	if swap > 0 && swap < mem {
+		return fmt.Errorf("%s.memory_swap must be >= memory", field)
+	}
+	if r.PidsLimit < 0 {
+		return fmt.Errorf("%s.pids_limit must be >= 0", field)
+	}
+	if r.BlkioWeight != 0 && (r.BlkioWeight < 10 || r.BlkioWeight > 1000) {
+		return fmt.Errorf("%s.blkio_weight must be in 10..1000", field)
+	}
+	for name, v := range r.Ulimits {
+		if v <= 0 {
+			return fmt.Errorf("%s.ulimits.%s must be > 0", field, name)
+		}
+		// The engines know the same names as docker run --ulimit.
+		if _, err := units.ParseUlimit(fmt.Sprintf("%s=%d", name, v)); err != nil {
+			return fmt.Errorf("%s.ulimits.%s is not a supported ulimit", field, name)
+		}
+	}
+	return nil
+}
+
+// Within reports the first limit in r that is above the same limit in max.
+// A zero limit in max means the limit is not capped.
+func (r *Resources) Within(max *Resources) error {
+	if max.CPUs > 0 && r.CPUs > max.CPUs {
+		return fmt.Errorf("cpus %g is above the maximum %g", r.CPUs, max.CPUs)
+	}
+	mem, _ := r.MemoryBytes()
+	maxMem, _ := max.MemoryBytes()
+	if maxMem > 0 && mem > maxMem {
+		return fmt.Errorf("memory %s is above the maximum %s", r.Memory, max.Memory)
+	}
+	swap, _ := r.MemorySwapBytes()
+	maxSwap, _ := max.MemorySwapBytes()
+	if maxSwap > 0 && swap > maxSwap {
+		return fmt.Errorf("memory_swap %s is above the maximum %s", r.MemorySwap, max.MemorySwap)
+	}
+	if max.PidsLimit > 0 && r.PidsLimit > max.PidsLimit {
+		return fmt.Errorf("pids_limit %d is above the maximum %d", r.PidsLimit, max.PidsLimit)
+	}
+	if max.BlkioWeight > 0 && r.BlkioWeight > max.BlkioWeight {
+		return fmt.Errorf("blkio_weight %d is above the maximum %d", r.BlkioWeight, max.BlkioWeight)
+	}
+	for name, v := range r.Ulimits {
+		if m, ok := max.Ulimits[name]; ok && v > m {
+			return fmt.Errorf("ulimit %s %d is above the maximum %d", name, v, m)
+		}
+	}
+	return nil
+}
+
+// ParseSize parses a docker style size ("512m"). Empty means 0.
+func ParseSize(s string) (int64, error) {
+	if strings.TrimSpace(s) == "" {
+		return 0, nil
+	}
+	return units.RAMInBytes(s)
+}
+
+// Security hardens session containers. The zero value keeps the engine's
+// defaults.
+type Security struct {
+	CapDrop         []string `yaml:"cap_drop"`
+	CapAdd          []string `yaml:"cap_add"`
+	NoNewPrivileges bool     `yaml:"no_new_privileges"`
+	// ReadOnlyRootfs mounts the image read-only. Tmpfs lists the writable
+	// scratch dirs; empty means /tmp plus nvim's state and cache dirs.
+	ReadOnlyRootfs bool     `yaml:"read_only_rootfs"`
+	Tmpfs          []string `yaml:"tmpfs"`
+	TmpfsSize      string   `yaml:"tmpfs_size"`
+	// SeccompProfile is a path to a JSON profile on the gateway host.
+	// AppArmorProfile is the name of a profile loaded on the engine host.
+	SeccompProfile  string `yaml:"seccomp_profile"`
+	AppArmorProfile string `yaml:"apparmor_profile"`
+	// OCIRuntime selects an alternative engine runtime such as "runsc".
+	OCIRuntime string `yaml:"oci_runtime"`
+}
+
+// Session network modes.
+const (
+	// NetworkBridge is the engine's default network with full egress.
+	NetworkBridge = "bridge"
+	// NetworkNone gives the container a loopback interface only.
+	NetworkNone = "none"
+	// NetworkInternal joins a gateway-managed network without a route out.
+	NetworkInternal = "internal"
+	// NetworkProxy is NetworkInternal plus HTTP(S) egress through the
+	// gateway's allowlisting proxy.
+	NetworkProxy = "proxy"
+)
+
+type Network struct {
+	// Mode is used when a request does not pick one; a request may pick
+	// any of AllowedModes.
+	Mode            string       `yaml:"mode"`
+	AllowedModes    []string     `yaml:"allowed_modes"`
+	InternalNetwork string       `yaml:"internal_network"`
+	Proxy           *EgressProxy `yaml:"proxy"`
+}
+
+type EgressProxy struct {
+	// Listen is where the proxy listens. Without a host, as in the
+	// default ":3128", it listens on the internal network's gateway IP
+	// only; "0.0.0.0:3128" is every interface.
+	Listen string `yaml:"listen"`
+	// AdvertiseAddr is the proxy address as seen from session containers.
+	// Empty means the internal network's gateway IP and the Listen port.
+	AdvertiseAddr string `yaml:"advertise_addr"`
+	// AllowedHosts are exact host names or "*.example.com" wildcards.
+	AllowedHosts []string `yaml:"allowed_hosts"`
+}
+
+// Allows reports whether mode may be requested for a session.
+func (n *Network) Allows(mode string) bool {
+	return slices.Contains(n.AllowedModes, mode)
+}
+
+// NeedsProxy reports whether the egress proxy must run: proxy sessions use
+// it, and internal sessions clone and push through it.
+func (n *Network) NeedsProxy() bool {
+	return n.Allows(NetworkProxy) || n.Allows(NetworkInternal)
+}
+
+// Uploads bound the archives POST /sessions/new accepts to seed a
+// workspace. Sizes take the same units as memory limits.
+type Uploads struct {
+	MaxArchiveSize   string `yaml:"max_archive_size"`
+	MaxExtractedSize string `yaml:"max_extracted_size"`
+	MaxFiles         int    `yaml:"max_files"`
+	// Timeout is how long receiving an upload may take.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// ArchiveBytes is the largest accepted upload. Load has validated it.
+func (u *Uploads) ArchiveBytes() int64 {
+	n, _ := ParseSize(u.MaxArchiveSize)
+	return n
+}
+
+// ExtractedBytes is the most an archive may expand to. Load has validated it.
+func (u *Uploads) ExtractedBytes() int64 {
+	n, _ := ParseSize(u.MaxExtractedSize)
+	return n
+}
+
+// Repos restricts which repositories sessions may clone.
+type Repos struct {
+	// AllowedSchemes are git transports: https, http, ssh, git or file.
+	AllowedSchemes []string `yaml:"allowed_schemes"`
+	// AllowedHosts, when set, are the only hosts that may be cloned from.
+	// Both lists take exact names or "*.example.com" wildcards.
+	AllowedHosts []string `yaml:"allowed_hosts"`
+	DeniedHosts  []string `yaml:"denied_hosts"`
+	// AllowPrivateAddresses lets hosts resolve to loopback, private and
+	// link-local addresses.
+	AllowPrivateAddresses bool `yaml:"allow_private_addresses"`
+}
+
+var repoSchemes = []string{"https", "http", "ssh", "git", "file"}
+
+// Reaper closes sessions that were abandoned or have run for too long.
+// Clients are warned WarnBefore the session is closed.
+type Reaper struct {
+	// Interval is how often sessions are checked.
+	Interval time.Duration `yaml:"interval"`
+	// IdleTimeout closes a session whose terminal had no input or output
+	// for this long. A negative value such as -1s disables it.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// UnattachedTimeout closes a session no client attached to within this
+	// long of it becoming ready.
+	UnattachedTimeout time.Duration `yaml:"unattached_timeout"`
+	// MaxLifetime closes a session this long after it was created. A
+	// negative value such as -1s disables it.
+	MaxLifetime time.Duration `yaml:"max_lifetime"`
+	WarnBefore  time.Duration `yaml:"warn_before"`
+}
+
+// Pool keeps sessions started ahead of requests, so they open at once.
+type Pool struct {
+	// Size is how many idle sessions are kept ready. Zero disables it.
+	Size int `yaml:"size"`
+	// Repo is cloned into every pooled workspace. Only requests for this
+	// repo, without a ref, depth, sparse checkout or credentials, get a
+	// pooled session; without it, only requests with no repo do.
+	Repo string `yaml:"repo"`
+}
+
+// Registry is where sessions are recorded.
+type Registry struct {
+	// Backend is "bolt", a file at Path that survives restarts, or
+	// "memory".
+	Backend string `yaml:"backend"`
+	Path    string `yaml:"path"`
+	// Retention drops records of sessions closed longer ago than this.
+	Retention time.Duration `yaml:"retention"`
+}
+
+// Shutdown is how the gateway drains its sessions on SIGINT or SIGTERM.
+type Shutdown struct {
+	// SaveGrace is how long attached clients are given to save their work
+	// after they were told the gateway is going down. Zero doesn't wait.
+	SaveGrace time.Duration `yaml:"save_grace"`
+	// Timeout bounds closing every session once the grace has passed.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Workspaces configures named workspaces, which outlive their sessions.
+type Workspaces struct {
+	// Path holds every owner's workspaces. Like base_path it must be the
+	// same path on the gateway and the container engine's host.
+	Path string `yaml:"path"`
+	// OwnerHeader names the request header an authenticating proxy in
+	// front of the gateway puts the user in.
+	OwnerHeader string `yaml:"owner_header"`
+	// TrustedProxies are the addresses and CIDR ranges of those proxies.
+	// The header of a request from anywhere else is ignored; empty
+	// ignores it everywhere.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// DefaultOwner is used when the header is missing. Empty means
+	// requests without it can't use named workspaces.
+	DefaultOwner string `yaml:"default_owner"`
+	// Retention deletes workspaces that were not used for this long.
+	// Zero keeps them until they are deleted.
+	Retention   time.Duration `yaml:"retention"`
+	MaxPerOwner int           `yaml:"max_per_owner"`
+}
+
+// TrustsProxy reports whether the owner header of a request from
+// remoteAddr, in the host:port form of http.Request.RemoteAddr, was set by
+// one of the TrustedProxies.
+func (w *Workspaces) TrustsProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	for _, p := range w.TrustedProxies {
+		if prefix, err := parsePrefix(p); err == nil && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix reads a CIDR range, or a single address as a range of one.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	return netip.ParsePrefix(s)
+}
+
 type SessionRuntime struct {
-	ImageName      string `yaml:"image_name"`
-	BasePath       string `yaml:"base_path"`
-	NvimConfigPath string `yaml:"nvim_config_path"`
-	WS             *WS    `yaml:"ws"`
+	Runtime        string  `yaml:"runtime"`
+	ImageName      string  `yaml:"image_name"`
+	BasePath       string  `yaml:"base_path"`
+	NvimConfigPath string  `yaml:"nvim_config_path"`
+	NvimBinary     string  `yaml:"nvim_binary"`
+	WS             *WS     `yaml:"ws"`
+	Podman         *Podman `yaml:"podman"`
+	// Resources are applied to every session; MaxResources bounds what a
+	// single request may ask for on top of them.
+	Resources    *Resources `yaml:"resources"`
+	MaxResources *Resources `yaml:"max_resources"`
+	Security     *Security  `yaml:"security"`
+	Network      *Network   `yaml:"network"`
+	// DetachGrace is how long a session survives without a client before
+	// it is closed.
+	DetachGrace time.Duration `yaml:"detach_grace"`
+	// ScrollbackBytes of PTY output are kept per session and replayed to
+	// a client on attach.
+	ScrollbackBytes int `yaml:"scrollback_bytes"`
+	// PostCloneHooks are argv lists run in the workspace after the clone
+	// and before the editor starts, each bounded by HookTimeout.
+	PostCloneHooks [][]string    `yaml:"post_clone_hooks"`
+	HookTimeout    time.Duration `yaml:"hook_timeout"`
+	// CloneDepth is the history depth fetched when a request doesn't set
+	// one. -1 fetches the full history.
+	CloneDepth int `yaml:"clone_depth"`
+	// SSHKnownHosts pins host keys for clones with a deploy key. Without
+	// it ssh's own known_hosts files are used; unknown hosts are refused.
+	SSHKnownHosts string `yaml:"ssh_known_hosts"`
+	Repos         *Repos `yaml:"repos"`
+	// ExecImage runs the clone and post-clone hooks in container runtimes
+	// and must contain git. Empty means ImageName.
+	ExecImage string   `yaml:"exec_image"`
+	Uploads   *Uploads `yaml:"uploads"`
+
+	Workspaces *Workspaces `yaml:"workspaces"`
+	Reaper     *Reaper     `yaml:"reaper"`
+	Shutdown   *Shutdown   `yaml:"shutdown"`
+	Registry   *Registry   `yaml:"registry"`
+	Pool       *Pool       `yaml:"pool"`
+
+	// GatewayID labels the containers this gateway creates. Gateways that
+	// share a container engine need different ids, or each one reconciles
+	// the other's sessions away on startup.
+	GatewayID string `yaml:"gateway_id"`
 }
 
 type Config struct {
@@ -49,6 +411,12 @@ func Load(path string) (*Config, error) {
 	if err := yaml.Unmarshal(b, &c); err != nil {
 		return nil, fmt.Errorf("parse config: %w", err)
 	}
+	// doc tells a zero written in the file from one left out, which gets
+	// the default.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
 
 	// ---------------------------------------------------------------------
 	// Defaults
@@ -67,9 +435,140 @@ func Load(path string) (*Config, error) {
 	if c.SessionRuntime == nil {
 		c.SessionRuntime = &SessionRuntime{}
 	}
+	if c.SessionRuntime.Runtime == "" {
+		c.SessionRuntime.Runtime = RuntimeDocker
+	}
+	if c.SessionRuntime.Podman == nil {
+		c.SessionRuntime.Podman = &Podman{}
+	}
+	if c.SessionRuntime.Resources == nil {
+		c.SessionRuntime.Resources = &Resources{}
+	}
+	if c.SessionRuntime.MaxResources == nil {
+		max := *c.SessionRuntime.Resources
+		c.SessionRuntime.MaxResources = &max
+	}
+	if c.SessionRuntime.Security == nil {
+		c.SessionRuntime.Security = &Security{}
+	}
+	if c.SessionRuntime.Uploads == nil {
+		c.SessionRuntime.Uploads = &Uploads{}
+	}
+	up := c.SessionRuntime.Uploads
+	if up.MaxArchiveSize == "" {
+		up.MaxArchiveSize = "100MiB"
+	}
+	if up.MaxExtractedSize == "" {
+		up.MaxExtractedSize = "1GiB"
+	}
+	if up.MaxFiles == 0 {
+		up.MaxFiles = 20000
+	}
+	if up.Timeout == 0 {
+		up.Timeout = 5 * time.Minute
+	}
+
+	if c.SessionRuntime.Repos == nil {
+		c.SessionRuntime.Repos = &Repos{}
+	}
+	if len(c.SessionRuntime.Repos.AllowedSchemes) == 0 {
+		c.SessionRuntime.Repos.AllowedSchemes = []string{"https", "ssh"}
+	}
+
+	if c.SessionRuntime.Network == nil {
+		c.SessionRuntime.Network = &Network{}
+	}
+	nw := c.SessionRuntime.Network
+	if nw.Mode == "" {
+		nw.Mode = NetworkBridge
+	}
+	if len(nw.AllowedModes) == 0 {
+		nw.AllowedModes = []string{nw.Mode}
+	}
+	if nw.InternalNetwork == "" {
+		nw.InternalNetwork = "nvimanywhere-internal"
+	}
+	if nw.Proxy == nil {
+		nw.Proxy = &EgressProxy{}
+	}
+	if nw.Proxy.Listen == "" {
+		nw.Proxy.Listen = ":3128"
+	}
+	if c.SessionRuntime.DetachGrace == 0 {
+		c.SessionRuntime.DetachGrace = 5 * time.Minute
+	}
+	if c.SessionRuntime.ScrollbackBytes == 0 {
+		c.SessionRuntime.ScrollbackBytes = 256 * 1024
+	}
+	if c.SessionRuntime.HookTimeout == 0 {
+		c.SessionRuntime.HookTimeout = 5 * time.Minute
+	}
+	if c.SessionRuntime.CloneDepth == 0 {
+		c.SessionRuntime.CloneDepth = 1
+	}
+	if c.SessionRuntime.NvimBinary == "" {
+		c.SessionRuntime.NvimBinary = "nvim"
+	}
 	if c.SessionRuntime.BasePath == "" {
 		c.SessionRuntime.BasePath = "/workspaces"
 	}
+	if c.SessionRuntime.GatewayID == "" {
+		c.SessionRuntime.GatewayID = "nvimanywhere"
+	}
+	if c.SessionRuntime.Workspaces == nil {
+		c.SessionRuntime.Workspaces = &Workspaces{}
+	}
+	named := c.SessionRuntime.Workspaces
+	if named.Path == "" {
+		named.Path = strings.TrimSuffix(c.SessionRuntime.BasePath, "/") + "/.named"
+	}
+	if named.OwnerHeader == "" {
+		named.OwnerHeader = "X-Forwarded-User"
+	}
+	if c.SessionRuntime.Reaper == nil {
+		c.SessionRuntime.Reaper = &Reaper{}
+	}
+	reaper := c.SessionRuntime.Reaper
+	if reaper.Interval == 0 {
+		reaper.Interval = 30 * time.Second
+	}
+	if reaper.IdleTimeout == 0 {
+		reaper.IdleTimeout = 2 * time.Hour
+	}
+	if reaper.UnattachedTimeout == 0 {
+		reaper.UnattachedTimeout = 15 * time.Minute
+	}
+	if reaper.MaxLifetime == 0 {
+		reaper.MaxLifetime = 24 * time.Hour
+	}
+	if reaper.WarnBefore == 0 {
+		reaper.WarnBefore = 5 * time.Minute
+	}
+
+	if c.SessionRuntime.Shutdown == nil {
+		c.SessionRuntime.Shutdown = &Shutdown{}
+	}
+	if c.SessionRuntime.Shutdown.Timeout == 0 {
+		c.SessionRuntime.Shutdown.Timeout = 30 * time.Second
+	}
+
+	if c.SessionRuntime.Registry == nil {
+		c.SessionRuntime.Registry = &Registry{}
+	}
+	registry := c.SessionRuntime.Registry
+	if registry.Backend == "" {
+		registry.Backend = RegistryBolt
+	}
+	if registry.Path == "" {
+		registry.Path = strings.TrimSuffix(c.SessionRuntime.BasePath, "/") + "/.sessions.db"
+	}
+	if registry.Retention == 0 {
+		registry.Retention = 30 * 24 * time.Hour
+	}
+
+	if c.SessionRuntime.Pool == nil {
+		c.SessionRuntime.Pool = &Pool{}
+	}
 
 	if c.LogFilePath == "" {
 		c.LogFilePath = "/logs"
@@ -92,21 +591,192 @@ func Load(path string) (*Config, error) {
 	if v := os.Getenv("NVA_ENV"); v != "" {
 		c.Env = v
 	}
+	if v := os.Getenv("NVA_SESSION_RUNTIME"); v != "" {
+		c.SessionRuntime.Runtime = v
+	}
+	if v := os.Getenv("NVA_PODMAN_SOCKET"); v != "" {
+		c.SessionRuntime.Podman.Socket = v
+	}
 	if v := os.Getenv("NVA_NVIM_CONFIG_PATH"); v != "" {
 		c.SessionRuntime.NvimConfigPath = v
 	}
+	if v := os.Getenv("NVA_WORKSPACES_PATH"); v != "" {
+		named.Path = v
+	}
+	if v := os.Getenv("NVA_REGISTRY_PATH"); v != "" {
+		registry.Path = v
+	}
+	if v := os.Getenv("NVA_GATEWAY_ID"); v != "" {
+		c.SessionRuntime.GatewayID = v
+	}
 
 	// ---------------------------------------------------------------------
 	// Validation
 	// ---------------------------------------------------------------------
 
-	if strings.TrimSpace(c.SessionRuntime.ImageName) == "" {
-		return nil, errors.New("session_runtime.image_name is required")
+	switch c.SessionRuntime.Runtime {
+	case RuntimeDocker, RuntimePodman:
+		if strings.TrimSpace(c.SessionRuntime.ImageName) == "" {
+			return nil, errors.New("session_runtime.image_name is required")
+		}
+	case RuntimeLocal:
+	default:
+		return nil, fmt.Errorf("session_runtime.runtime %q is not supported", c.SessionRuntime.Runtime)
+	}
+
+	if p := c.SessionRuntime.Podman; p.UID < 0 || p.GID < 0 {
+		return nil, errors.New("session_runtime.podman.uid/gid must be >= 0")
 	}
 
 	if !isAbsolute(c.SessionRuntime.BasePath) {
 		return nil, errors.New("session_runtime.base_path must be absolute")
 	}
+	if !isAbsolute(named.Path) {
+		return nil, errors.New("session_runtime.workspaces.path must be absolute")
+	}
+	if named.Retention < 0 {
+		return nil, errors.New("session_runtime.workspaces.retention must be >= 0")
+	}
+	if named.MaxPerOwner < 0 {
+		return nil, errors.New("session_runtime.workspaces.max_per_owner must be >= 0")
+	}
+	for _, p := range named.TrustedProxies {
+		if _, err := parsePrefix(p); err != nil {
+			return nil, fmt.Errorf("session_runtime.workspaces.trusted_proxies: %q is not an address or CIDR range", p)
+		}
+	}
+
+	if err := c.SessionRuntime.Resources.Validate("session_runtime.resources"); err != nil {
+		return nil, err
+	}
+	if err := c.SessionRuntime.MaxResources.Validate("session_runtime.max_resources"); err != nil {
+		return nil, err
+	}
+	if err := c.SessionRuntime.Resources.Within(c.SessionRuntime.MaxResources); err != nil {
+		return nil, fmt.Errorf("session_runtime.resources: %w", err)
+	}
+
+	sec := c.SessionRuntime.Security
+	if sec.SeccompProfile != "" && !isAbsolute(sec.SeccompProfile) {
+		return nil, errors.New("session_runtime.security.seccomp_profile must be absolute")
+	}
+	if _, err := ParseSize(sec.TmpfsSize); err != nil {
+		return nil, fmt.Errorf("session_runtime.security.tmpfs_size: %w", err)
+	}
+	for _, dir := range sec.Tmpfs {
+		if !isAbsolute(dir) {
+			return nil, fmt.Errorf("session_runtime.security.tmpfs %q must be absolute", dir)
+		}
+	}
+
+	for field, size := range map[string]string{
+		"max_archive_size":   up.MaxArchiveSize,
+		"max_extracted_size": up.MaxExtractedSize,
+	} {
+		if n, err := ParseSize(size); err != nil || n <= 0 {
+			return nil, fmt.Errorf("session_runtime.uploads.%s must be a size > 0", field)
+		}
+	}
+	if up.MaxFiles < 0 || explicitZero(&doc, "session_runtime", "uploads", "max_files") {
+		return nil, errors.New("session_runtime.uploads.max_files must be > 0")
+	}
+	if up.Timeout < 0 || explicitZero(&doc, "session_runtime", "uploads", "timeout") {
+		return nil, errors.New("session_runtime.uploads.timeout must be > 0")
+	}
+
+	for _, scheme := range c.SessionRuntime.Repos.AllowedSchemes {
+		if !slices.Contains(repoSchemes, scheme) {
+			return nil, fmt.Errorf("session_runtime.repos.allowed_schemes: %q is not supported", scheme)
+		}
+		// git:// can't be pinned to the address a repo was checked at.
+		if scheme == "git" && !c.SessionRuntime.Repos.AllowPrivateAddresses {
+			return nil, errors.New("session_runtime.repos.allowed_schemes: \"git\" needs allow_private_addresses")
+		}
+	}
+
+	for _, mode := range nw.AllowedModes {
+		switch mode {
+		case NetworkBridge:
+		case NetworkNone, NetworkInternal, NetworkProxy:
+			if c.SessionRuntime.Runtime == RuntimeLocal {
+				return nil, fmt.Errorf("session_runtime.network mode %q needs a container runtime", mode)
+			}
+		default:
+			return nil, fmt.Errorf("session_runtime.network mode %q is not supported", mode)
+		}
+	}
+	if !nw.Allows(nw.Mode) {
+		return nil, errors.New("session_runtime.network.mode must be one of allowed_modes")
+	}
+	if _, _, err := net.SplitHostPort(nw.Proxy.Listen); err != nil {
+		return nil, fmt.Errorf("session_runtime.network.proxy.listen: %w", err)
+	}
+
+	if c.SessionRuntime.DetachGrace < 0 || explicitZero(&doc, "session_runtime", "detach_grace") {
+		return nil, errors.New("session_runtime.detach_grace must be > 0")
+	}
+
+	for field, d := range map[string]time.Duration{
+		"interval":           reaper.Interval,
+		"unattached_timeout": reaper.UnattachedTimeout,
+		"warn_before":        reaper.WarnBefore,
+	} {
+		if d < 0 || explicitZero(&doc, "session_runtime", "reaper", field) {
+			return nil, fmt.Errorf("session_runtime.reaper.%s must be > 0", field)
+		}
+	}
+	// Negative idle_timeout and max_lifetime are disabled limits.
+	for field, d := range map[string]time.Duration{
+		"idle_timeout": reaper.IdleTimeout,
+		"max_lifetime": reaper.MaxLifetime,
+	} {
+		if explicitZero(&doc, "session_runtime", "reaper", field) {
+			return nil, fmt.Errorf("session_runtime.reaper.%s must be > 0, or negative to disable it", field)
+		}
+		if d >= 0 && reaper.WarnBefore >= d {
+			return nil, fmt.Errorf("session_runtime.reaper.warn_before must be < %s", field)
+		}
+	}
+
+	if sd := c.SessionRuntime.Shutdown; sd.SaveGrace < 0 {
+		return nil, errors.New("session_runtime.shutdown.save_grace must be >= 0")
+	} else if sd.Timeout < 0 || explicitZero(&doc, "session_runtime", "shutdown", "timeout") {
+		return nil, errors.New("session_runtime.shutdown.timeout must be > 0")
+	}
+
+	switch registry.Backend {
+	case RegistryBolt, RegistryMemory:
+	default:
+		return nil, fmt.Errorf("session_runtime.registry.backend must be %q or %q", RegistryBolt, RegistryMemory)
+	}
+	if registry.Retention < 0 || explicitZero(&doc, "session_runtime", "registry", "retention") {
+		return nil, errors.New("session_runtime.registry.retention must be > 0")
+	}
+
+	if c.SessionRuntime.Pool.Size < 0 {
+		return nil, errors.New("session_runtime.pool.size must be >= 0")
+	}
+	// Pooled containers keep their pooled label once handed out, so only
+	// a durable record tells a claimed session from an idle one on restart.
+	if c.SessionRuntime.Pool.Size > 0 && registry.Backend != RegistryBolt {
+		return nil, fmt.Errorf("session_runtime.pool needs the %q registry backend", RegistryBolt)
+	}
+
+	if c.SessionRuntime.ScrollbackBytes < 0 || explicitZero(&doc, "session_runtime", "scrollback_bytes") {
+		return nil, errors.New("session_runtime.scrollback_bytes must be > 0")
+	}
+
+	for i, hook := range c.SessionRuntime.PostCloneHooks {
+		if len(hook) == 0 || strings.TrimSpace(hook[0]) == "" {
+			return nil, fmt.Errorf("session_runtime.post_clone_hooks[%d] is empty", i)
+		}
+	}
+	if c.SessionRuntime.HookTimeout < 0 || explicitZero(&doc, "session_runtime", "hook_timeout") {
+		return nil, errors.New("session_runtime.hook_timeout must be > 0")
+	}
+	if c.SessionRuntime.CloneDepth < -1 || explicitZero(&doc, "session_runtime", "clone_depth") {
+		return nil, errors.New("session_runtime.clone_depth must be > 0, or -1 for the full history")
+	}
 
 	if c.SessionRuntime.WS == nil {
 		return nil, errors.New("session_runtime.ws is required")
@@ -137,7 +807,40 @@ func Load(path string) (*Config, error) {
 	return &c, nil
 }
 
+// explicitZero reports whether the YAML document sets the key at path to
+// zero. Load gives such fields their default, so a zero that was written
+// down is refused instead of silently replaced.
+func explicitZero(doc *yaml.Node, path ...string) bool {
+	n := doc
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	for _, key := range path {
+		if n.Kind != yaml.MappingNode {
+			return false
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				next = n.Content[i+1]
+			}
+		}
+		if next == nil {
+			return false
+		}
+		n = next
+	}
+	if n.Kind != yaml.ScalarNode || n.Tag == "!!null" {
+		return false
+	}
+	var d time.Duration
+	if n.Decode(&d) == nil {
+		return d == 0
+	}
+	var f float64
+	return n.Decode(&f) == nil && f == 0
+}
+
 func isAbsolute(p string) bool {
 	return strings.HasPrefix(p, "/")
 }
-
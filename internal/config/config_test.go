@@ -0,0 +1,103 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// load writes a minimal config with extra appended under session_runtime
+// and loads it.
+func load(t *testing.T, extra string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `
+session_runtime:
+  runtime: local
+  base_path: /workspaces
+  ws:
+    max_message_size: 32768
+    read_timeout: 20s
+    write_timeout: 10s
+    ping_interval: 10s
+` + extra
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestLoadRejectsExplicitZero(t *testing.T) {
+	for _, tc := range []struct {
+		extra string
+		field string
+	}{
+		{"  detach_grace: 0s\n", "detach_grace"},
+		{"  clone_depth: 0\n", "clone_depth"},
+		{"  registry:\n    retention: 0s\n", "registry.retention"},
+		{"  shutdown:\n    timeout: 0s\n", "shutdown.timeout"},
+		{"  reaper:\n    interval: 0s\n", "reaper.interval"},
+		{"  reaper:\n    warn_before: 0s\n", "reaper.warn_before"},
+		{"  reaper:\n    idle_timeout: 0s\n", "reaper.idle_timeout"},
+		{"  uploads:\n    max_files: 0\n", "uploads.max_files"},
+		{"  uploads:\n    timeout: 0s\n", "uploads.timeout"},
+		{"  hook_timeout: 0s\n", "hook_timeout"},
+		{"  scrollback_bytes: 0\n", "scrollback_bytes"},
+	} {
+		if _, err := load(t, tc.extra); err == nil || !strings.Contains(err.Error(), tc.field+" must be > 0") {
+			t.Errorf("%q: err = %v, want %s refused", tc.extra, err, tc.field)
+		}
+	}
+}
+
+func TestLoadDefaultsOmittedFields(t *testing.T) {
+	c, err := load(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SessionRuntime.DetachGrace <= 0 {
+		t.Errorf("detach_grace = %v, want the default", c.SessionRuntime.DetachGrace)
+	}
+}
+
+func TestLoadAcceptsZeroSaveGrace(t *testing.T) {
+	if _, err := load(t, "  shutdown:\n    save_grace: 0s\n"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadAcceptsFullHistoryCloneDepth(t *testing.T) {
+	c, err := load(t, "  clone_depth: -1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SessionRuntime.CloneDepth != -1 {
+		t.Fatalf("clone_depth = %d, want -1", c.SessionRuntime.CloneDepth)
+	}
+}
+
+func TestWorkspacesTrustsProxy(t *testing.T) {
+	w := &Workspaces{TrustedProxies: []string{"10.0.0.0/8", "::1"}}
+	for addr, want := range map[string]bool{
+		"10.1.2.3:4567":        true,
+		"[::ffff:10.1.2.3]:80": true,
+		"[::1]:80":             true,
+		"127.0.0.1:80":         false,
+		"192.0.2.1:1234":       false,
+		"not an address":       false,
+	} {
+		if got := w.TrustsProxy(addr); got != want {
+			t.Errorf("TrustsProxy(%q) = %v, want %v", addr, got, want)
+		}
+	}
+	if (&Workspaces{}).TrustsProxy("10.1.2.3:4567") {
+		t.Error("no trusted proxies trusts a request")
+	}
+}
+
+func TestLoadRejectsBadTrustedProxy(t *testing.T) {
+	if _, err := load(t, "  workspaces:\n    trusted_proxies: [\"10.0.0.0/33\"]\n"); err == nil {
+		t.Fatal("Load accepted an invalid trusted proxy")
+	}
+}
@@ -0,0 +1,176 @@
+package egress
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Proxy is a forward HTTP(S) proxy for session containers on the internal
+// network. Plain requests and CONNECT tunnels are only let through to
+// hosts on the allowlist.
+type Proxy struct {
+	allowed   []string
+	log       *slog.Logger
+	dialer    *net.Dialer
+	transport *http.Transport
+}
+
+func New(allowedHosts []string, log *slog.Logger) *Proxy {
+	allowed := make([]string, 0, len(allowedHosts))
+	for _, h := range allowedHosts {
+		allowed = append(allowed, strings.ToLower(strings.TrimSpace(h)))
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	return &Proxy{
+		allowed: allowed,
+		log:     log,
+		dialer:  dialer,
+		transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// Allowed reports whether host (without port) matches the allowlist.
+func (p *Proxy) Allowed(host string) bool {
+	return MatchHost(p.allowed, host)
+}
+
+// MatchHost reports whether host (without port) matches one of patterns.
+// "example.com" matches itself only, "*.example.com" matches subdomains.
+func MatchHost(patterns []string, host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, a := range patterns {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if suffix, ok := strings.CutPrefix(a, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+			continue
+		}
+		if host == a {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.handleConnect(w, r)
+		return
+	}
+	p.handleForward(w, r)
+}
+
+func (p *Proxy) handleConnect(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, "CONNECT target must be host:port", http.StatusBadRequest)
+		return
+	}
+	if !p.Allowed(host) {
+		p.deny(w, r, host)
+		return
+	}
+
+	upstream, err := p.dialer.DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, "upstream unreachable", http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		// Bytes the client sent after the CONNECT line are already buffered.
+		io.Copy(upstream, buf)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+	client.Close()
+	upstream.Close()
+}
+
+// hopHeaders are meaningful for a single connection only.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func (p *Proxy) handleForward(w http.ResponseWriter, r *http.Request) {
+	if !r.URL.IsAbs() || r.URL.Scheme != "http" {
+		http.Error(w, "only absolute http:// URLs can be proxied", http.StatusBadRequest)
+		return
+	}
+	if !p.Allowed(r.URL.Hostname()) {
+		p.deny(w, r, r.URL.Hostname())
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, "upstream request failed", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for k, vv := range resp.Header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func (p *Proxy) deny(w http.ResponseWriter, r *http.Request, host string) {
+	p.log.Warn("egress denied",
+		"host", host,
+		"method", r.Method,
+		"remote", r.RemoteAddr,
+	)
+	http.Error(w, "egress to "+host+" is not allowed", http.StatusForbidden)
+}
@@ -0,0 +1,89 @@
+package egress
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	p := New([]string{"proxy.golang.org", "*.github.com"}, slog.New(slog.DiscardHandler))
+
+	tests := map[string]bool{
+		"proxy.golang.org":      true,
+		"PROXY.golang.org.":     true,
+		"evil-proxy.golang.org": false,
+		"api.github.com":        true,
+		"codeload.github.com":   true,
+		"github.com":            false,
+		"github.com.evil.test":  false,
+		"169.254.169.254":       false,
+		"internal.corp.example": false,
+	}
+	for host, want := range tests {
+		if got := p.Allowed(host); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestForwardAllowsListedHostOnly(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer upstream.Close()
+
+	p := New([]string{"127.0.0.1"}, slog.New(slog.DiscardHandler))
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Fatalf("allowed request = %d %q", resp.StatusCode, body)
+	}
+
+	denied := strings.Replace(upstream.URL, "127.0.0.1", "localhost", 1)
+	resp, err = client.Get(denied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("denied request status = %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestConnectDeniedHost(t *testing.T) {
+	p := New(nil, slog.New(slog.DiscardHandler))
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("CONNECT status = %d, want 403", resp.StatusCode)
+	}
+}
@@ -2,8 +2,13 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"nvimanywhere/internal/registry"
+	"nvimanywhere/internal/sessions"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -66,11 +71,12 @@ func (app *App) sessionUIHandler(w http.ResponseWriter, r *http.Request) {
 // the client to an already-running session.
 //
 // Lifecycle:
-//   1. Parse token
+//   1. Parse token and look up the session
 //   2. Accept WebSocket upgrade
-//   3. Attach session (ownership boundary)
+//   3. Attach session (a newer client takes over an older one)
 //   4. Wait for disconnect or server shutdown
-//   5. Cleanup session + connection
+//   5. Close the session if nvim exited, otherwise keep it for
+//      session_runtime.detach_grace so the client can reattach
 //
 // IMPORTANT:
 //   After websocket.Accept succeeds, HTTP is no longer valid.
@@ -83,6 +89,15 @@ func (app *App) handleSession(w http.ResponseWriter, r *http.Request) {
 		app.respondError(w, http.StatusBadRequest, "token is not provided", nil)
 		return
 	}
+
+	app.mu.Lock()
+	sess := app.sessions[token]
+	app.mu.Unlock()
+	if sess == nil {
+		app.respondError(w, http.StatusNotFound, "session not found", nil)
+		return
+	}
+
 	conn, err := app.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		app.log.Error(err.Error())
@@ -90,27 +105,129 @@ func (app *App) handleSession(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	app.mu.Lock()
-	sess := app.sessions[token]
-	if ok {
-		delete(app.sessions, token) // prevent reuse
+	err = sess.Attach(conn)
+	switch {
+	case errors.Is(err, sessions.SessionIsTakenOver):
+		sendControl(r.Context(), conn, "replaced", "Session was opened in another window")
+		return
+	case errors.Is(err, sessions.SessionIsFailed):
+		// watchBoot closes it once its status had time to be read.
+		sendControl(r.Context(), conn, "exit", err.Error())
+		return
+	case errors.Is(err, sessions.SessionIsClosed):
+		sendControl(r.Context(), conn, "exit", err.Error())
+		app.closeSession(token, sess)
+		return
+	case err != nil && app.ctx.Err() == nil:
+		app.log.Debug("session detached", "token", token, "err", err)
 	}
+
+	app.mu.Lock()
+	draining := app.draining
 	app.mu.Unlock()
-	if !ok || sess == nil {
-		app.respondError(w, http.StatusNotFound, "session not found", nil)
+	if draining {
+		// Shutdown closes the session and has told the client why.
 		return
 	}
-	defer func() {
-		if err := sess.Close(); err != nil {
-			app.log.Error(err.Error())
+	if sess.Exited() {
+		sendControl(r.Context(), conn, "exit", "nvim exited")
+		app.closeSession(token, sess)
+		return
+	}
+	app.expireDetached(token, sess)
+}
+
+// expireDetached closes sess unless a client reattaches within the grace
+// period. Every detach arms its own timer; timers that fire while the
+// session is attached, or detached more recently, do nothing.
+func (app *App) expireDetached(token string, sess *sessions.Session) {
+	grace := app.cfg.SessionRuntime.DetachGrace
+	time.AfterFunc(grace, func() {
+		if sess.DetachedFor() < grace {
+			return
 		}
-	}()
+		app.log.Info("session expired after detach", "token", token, "grace", grace)
+		app.closeSession(token, sess)
+	})
+}
 
-	if err := sess.Attach(conn); err != nil {
-		app.log.Error(err.Error())
-		sendControl(r.Context(), conn, "session failed", "Fail within session's attach")
+// Reconcile adopts the sessions sessions.Reconcile finds still running
+// from an earlier gateway process, and has it clean up the rest. Adopted
+// sessions are detached, so each gets the grace period for its client to
+// reconnect, and a named workspace is marked in use again until its
+// session closes. Records of the sessions that were lost are closed.
+// It must run once before the app serves, and starts the session pool.
+func (app *App) Reconcile() error {
+	adopted, err := sessions.Reconcile(app.ctx, app.runtime, app.cfg.SessionRuntime)
+	for _, sess := range adopted {
+		token := sess.Token()
+		// Pooled sessions nobody was handed have no record; the new
+		// pool starts its own.
+		if sess.Label(sessions.LabelPooled) != "" {
+			if _, rerr := app.records.Get(token); errors.Is(rerr, registry.RecordIsNotFound) {
+				sess.Close()
+				continue
+			}
+		}
+		var release func()
+		if name := sess.Label(labelWorkspaceName); name != "" {
+			_, rel, err := app.workspaces.Open(sess.Label(labelOwner), name)
+			if err != nil {
+				app.log.Error("Failed to reopen adopted workspace", "token", token, "workspace", name, "err", err)
+			}
+			release = rel
+		}
+		app.mu.Lock()
+		app.sessions[token] = sess
+		if release != nil {
+			app.releases[token] = release
+		}
+		app.mu.Unlock()
+		app.log.Info("session adopted", "token", token)
+		go app.track(token, sess)
+		app.expireDetached(token, sess)
+	}
+	if rerr := app.closeLostRecords(); rerr != nil {
+		app.log.Error("Failed to close lost session records", "err", rerr)
+	}
+	// Filling only now keeps the pool's sessions out of Reconcile.
+	go app.pool.Run()
+	return err
+}
+
+// watchBoot keeps a session that failed to start around for the grace
+// period, so its status and error can still be read, then closes it.
+func (app *App) watchBoot(token string, sess *sessions.Session) {
+	<-sess.Booted()
+	if sess.State() != sessions.StateFailed {
 		return
 	}
+	app.log.Error("session failed to start", "token", token, "err", sess.Status().Error)
+	time.AfterFunc(app.cfg.SessionRuntime.DetachGrace, func() {
+		app.closeSession(token, sess)
+	})
+}
+
+// closeSession forgets the session and tears it down. A named workspace
+// is handed back only after the session is closed, so no other session
+// can open it while this one still writes to it.
+func (app *App) closeSession(token string, sess *sessions.Session) {
+	app.mu.Lock()
+	var release func()
+	if app.sessions[token] == sess {
+		delete(app.sessions, token)
+		release = app.releases[token]
+		delete(app.releases, token)
+	}
+	app.mu.Unlock()
+
+	if err := sess.Close(); err != nil {
+		app.log.Error(err.Error())
+	}
+	app.record(token, sess)
+	if release != nil {
+		release()
+	}
 }
 
 // ============================================================
@@ -140,7 +257,12 @@ func tokenFromPath(r *http.Request) (string, bool) {
 }
 
 func sendControl(ctx context.Context, ws *websocket.Conn, typ, msg string) {
-	_ = ws.WriteMessage(websocket.TextMessage,
-		[]byte(`{"type":"`+typ+`","reason":"`+msg+`"}`),
-	)
+	b, err := json.Marshal(struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}{typ, msg})
+	if err != nil {
+		return
+	}
+	_ = ws.WriteMessage(websocket.TextMessage, b)
 }
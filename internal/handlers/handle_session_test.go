@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"errors"
+	"nvimanywhere/internal/sessions"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAttachToFailedSessionKeepsItForStatus(t *testing.T) {
+	testRuntime.failStart(t, errors.New("boom"))
+	app, srv := newTestApp(t, 0)
+	token, sess := bootSession(t, app, srv)
+	if st := sess.State(); st != sessions.StateFailed {
+		t.Fatalf("session state = %s, want failed", st)
+	}
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/sessions/" + token
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	typ, reason, err := readControl(conn)
+	if err != nil || typ != "exit" || reason != sessions.SessionIsFailed.Error() {
+		t.Fatalf("control = %q %q, %v, want exit for the failure", typ, reason, err)
+	}
+	// The handler is done once it hangs up.
+	if _, _, err := readControl(conn); err == nil {
+		t.Fatal("connection still open after exit")
+	}
+
+	app.mu.Lock()
+	kept := app.sessions[token] == sess
+	app.mu.Unlock()
+	if !kept || sess.State() != sessions.StateFailed {
+		t.Fatalf("session kept = %v, state = %s; want it left for watchBoot", kept, sess.State())
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"log/slog"
 	"net/http"
 	"nvimanywhere/internal/config"
+	"nvimanywhere/internal/registry"
 	s "nvimanywhere/internal/sessions"
 	"nvimanywhere/internal/templates"
+	"nvimanywhere/internal/workspaces"
 	"sync"
 
 	"github.com/gorilla/websocket"
@@ -20,20 +22,56 @@ type App struct {
 	templates templates.TemplateCache
 	cfg       *config.Config
 	log       *slog.Logger
+	runtime   s.Runtime
 	sessions  map[string]*s.Session
 	upgrader  websocket.Upgrader
+
+	workspaces *workspaces.Store
+	// releases hand a named workspace back once its session has closed.
+	releases map[string]func()
+	// draining is set by Shutdown; no session is started after it.
+	draining bool
+
+	// records keeps a record of every session; recordMu orders writes.
+	records  registry.SessionStore
+	recordMu sync.Mutex
+	// pool hands out pre-started sessions; nil when it is disabled.
+	pool *s.Pool
 }
 
-func InitApp(cfg *config.Config, log *slog.Logger, t templates.TemplateCache, ctx context.Context) *App {
-	return &App{
-		ctx:       ctx,
-		mu:        sync.Mutex{},
-		templates: t,
-		cfg:       cfg,
-		log:       log,
-		sessions:  make(map[string]*s.Session),
-		upgrader:  websocket.Upgrader{},
+// InitApp returns the app serving sessions on runtime under ctx. Sessions
+// are only closed by Shutdown, so ctx should outlive the signal that
+// triggers it.
+func InitApp(cfg *config.Config, log *slog.Logger, t templates.TemplateCache, records registry.SessionStore, runtime s.Runtime, ctx context.Context) *App {
+	named := cfg.SessionRuntime.Workspaces
+	ctx, cancel := context.WithCancel(ctx)
+	app := &App{
+		ctx:        ctx,
+		cancel:     cancel,
+		mu:         sync.Mutex{},
+		templates:  t,
+		cfg:        cfg,
+		log:        log,
+		runtime:    runtime,
+		sessions:   make(map[string]*s.Session),
+		upgrader:   websocket.Upgrader{},
+		workspaces: workspaces.New(named.Path, named.MaxPerOwner),
+		releases:   make(map[string]func()),
+		records:    records,
+	}
+	pool, err := s.NewPool(ctx, runtime, cfg.SessionRuntime, func(err error) {
+		log.Error("Failed to fill session pool", "err", err)
+	})
+	if err != nil {
+		log.Error("Session pool is disabled", "err", err)
+	}
+	app.pool = pool
+	go app.reapSessions()
+	go app.pruneRecords(cfg.SessionRuntime.Registry.Retention)
+	if named.Retention > 0 {
+		go app.pruneWorkspaces(named.Retention)
 	}
+	return app
 }
 
 func (h *App) HandleHealth(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"fmt"
+	"nvimanywhere/internal/sessions"
+	"time"
+)
+
+// ============================================================
+// Session Reaper
+// ------------------------------------------------------------
+// reapSessions closes sessions that are idle, were never
+// attached, or have outlived session_runtime.reaper's limits.
+// An attached client gets a "warning" control message
+// warn_before the session closes, an empty one if activity
+// pushed the deadline back, and "exit" with the reason.
+// ============================================================
+
+func (app *App) reapSessions() {
+	limits := app.cfg.SessionRuntime.Reaper
+	ticker := time.NewTicker(limits.Interval)
+	defer ticker.Stop()
+
+	// warned holds the deadline each session was last warned about.
+	warned := make(map[*sessions.Session]time.Time)
+	for {
+		select {
+		case <-app.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		app.mu.Lock()
+		live := make(map[string]*sessions.Session, len(app.sessions))
+		for token, sess := range app.sessions {
+			live[token] = sess
+		}
+		app.mu.Unlock()
+
+		seen := make(map[*sessions.Session]bool, len(live))
+		now := time.Now()
+		for token, sess := range live {
+			seen[sess] = true
+			deadline, reason := sess.Expiry(limits)
+			switch {
+			case deadline.IsZero():
+			case !now.Before(deadline):
+				app.log.Info("reaping session", "token", token, "reason", reason)
+				sess.Notify("exit", "Session closed: "+reason)
+				go app.closeSession(token, sess)
+			case !now.Before(deadline.Add(-limits.WarnBefore)):
+				if warned[sess].Equal(deadline) {
+					continue
+				}
+				left := deadline.Sub(now).Round(time.Second)
+				if sess.Notify("warning", fmt.Sprintf("Session closes in %s: %s", left, reason)) {
+					warned[sess] = deadline
+				}
+			default:
+				if _, ok := warned[sess]; ok && sess.Notify("warning", "") {
+					delete(warned, sess)
+				}
+			}
+		}
+		for sess := range warned {
+			if !seen[sess] {
+				delete(warned, sess)
+			}
+		}
+	}
+}
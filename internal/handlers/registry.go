@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"nvimanywhere/internal/registry"
+	"nvimanywhere/internal/sessions"
+	"time"
+)
+
+// ============================================================
+// Session Registry
+// ------------------------------------------------------------
+// Every session gets a record in session_runtime.registry
+// that follows its state until it has closed. Records of
+// sessions an earlier gateway process lost are closed on
+// startup, and closed records are dropped after retention.
+// ============================================================
+
+// track keeps the record of sess up to date until it has closed.
+func (app *App) track(token string, sess *sessions.Session) {
+	updates, unsubscribe := sess.Subscribe()
+	defer unsubscribe()
+	app.record(token, sess)
+	for {
+		select {
+		case st := <-updates:
+			app.record(token, sess)
+			if st.State == sessions.StateClosed {
+				return
+			}
+		case <-app.ctx.Done():
+			return
+		}
+	}
+}
+
+// record writes the current state of sess. It reads the status under
+// recordMu, so a slow writer never replaces a newer record with an
+// older one.
+func (app *App) record(token string, sess *sessions.Session) {
+	app.recordMu.Lock()
+	defer app.recordMu.Unlock()
+	st := sess.Status()
+	rec := registry.Record{
+		Token:       token,
+		Owner:       sess.Label(labelOwner),
+		ContainerID: sess.RuntimeID(),
+		Workspace:   sess.Workspace(),
+		Repo:        sessions.RedactRepo(st.Repo),
+		State:       string(st.State),
+		CreatedAt:   st.CreatedAt,
+		UpdatedAt:   st.UpdatedAt,
+		ClosedAt:    st.Since[sessions.StateClosed],
+	}
+	if rec.Owner == "" {
+		// Adopted pooled sessions got their owner after their labels.
+		if old, err := app.records.Get(token); err == nil {
+			rec.Owner = old.Owner
+		}
+	}
+	if err := app.records.Put(rec); err != nil {
+		app.log.Error("Failed to record session", "token", token, "err", err)
+	}
+}
+
+// closeLostRecords closes the records of sessions that were open when an
+// earlier gateway process stopped and that it did not adopt.
+func (app *App) closeLostRecords() error {
+	records, err := app.records.List()
+	if err != nil {
+		return err
+	}
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	app.recordMu.Lock()
+	defer app.recordMu.Unlock()
+	now := time.Now()
+	for _, rec := range records {
+		if _, adopted := app.sessions[rec.Token]; adopted || !rec.ClosedAt.IsZero() {
+			continue
+		}
+		rec.State = string(sessions.StateClosed)
+		rec.UpdatedAt = now
+		rec.ClosedAt = now
+		if err := app.records.Put(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneRecords applies the registry's retention until the app shuts down.
+func (app *App) pruneRecords(retention time.Duration) {
+	ticker := time.NewTicker(min(retention, time.Hour))
+	defer ticker.Stop()
+	for {
+		n, err := app.records.Prune(time.Now().Add(-retention))
+		if err != nil {
+			app.log.Error("Failed to prune session records", "err", err)
+		}
+		if n > 0 {
+			app.log.Info("pruned session records", "count", n, "retention", retention)
+		}
+		select {
+		case <-app.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
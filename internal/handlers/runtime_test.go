@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"nvimanywhere/internal/sessions"
+	"sync"
+	"testing"
+)
+
+// testRuntime hosts every session the handler tests start.
+var testRuntime = &fakeRuntime{procs: make(map[string]*fakeProc)}
+
+// fakeRuntime is an in-process sessions.Runtime. Every attachment is a
+// loopback pipe, which is enough to drive the handlers without a
+// container daemon.
+type fakeRuntime struct {
+	mu     sync.Mutex
+	nextID int
+	procs  map[string]*fakeProc
+	// hold, when set, keeps Terminate from returning until it is closed.
+	hold chan struct{}
+	// startErr, when set, fails every Start.
+	startErr error
+}
+
+type fakeProc struct {
+	out        *io.PipeWriter
+	terminated bool
+}
+
+// failStart makes Start fail with err until the test ends.
+func (f *fakeRuntime) failStart(t *testing.T, err error) {
+	f.mu.Lock()
+	f.startErr = err
+	f.mu.Unlock()
+	t.Cleanup(func() {
+		f.mu.Lock()
+		f.startErr = nil
+		f.mu.Unlock()
+	})
+}
+
+// holdTerminate makes Terminate block until the test ends.
+func (f *fakeRuntime) holdTerminate(t *testing.T) {
+	hold := make(chan struct{})
+	f.mu.Lock()
+	f.hold = hold
+	f.mu.Unlock()
+	t.Cleanup(func() {
+		f.mu.Lock()
+		f.hold = nil
+		f.mu.Unlock()
+		close(hold)
+	})
+}
+
+func (f *fakeRuntime) Start(ctx context.Context, opts sessions.StartOptions) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.startErr != nil {
+		return "", f.startErr
+	}
+	f.nextID++
+	id := fmt.Sprintf("fake-%d", f.nextID)
+	f.procs[id] = &fakeProc{}
+	return id, nil
+}
+
+func (f *fakeRuntime) Attach(ctx context.Context, id string) (io.Reader, io.Writer, func() error, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.procs[id]
+	if !ok || p.terminated {
+		return nil, nil, nil, errors.New("fake: no such process")
+	}
+	pr, pw := io.Pipe()
+	p.out = pw
+	return pr, pw, func() error {
+		pw.Close()
+		return pr.Close()
+	}, nil
+}
+
+func (f *fakeRuntime) Resize(ctx context.Context, id string, cols, rows int) error {
+	return nil
+}
+
+func (f *fakeRuntime) Terminate(ctx context.Context, id string) error {
+	f.mu.Lock()
+	hold := f.hold
+	f.mu.Unlock()
+	if hold != nil {
+		<-hold
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.procs[id]
+	if !ok {
+		return errors.New("fake: no such process")
+	}
+	p.terminated = true
+	if p.out != nil {
+		p.out.Close()
+	}
+	return nil
+}
+
+func (f *fakeRuntime) Inspect(ctx context.Context, id string) (sessions.RuntimeInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.procs[id]
+	if !ok {
+		return sessions.RuntimeInfo{}, errors.New("fake: no such process")
+	}
+	return sessions.RuntimeInfo{ID: id, Running: !p.terminated}, nil
+}
+
+func (f *fakeRuntime) Exec(ctx context.Context, opts sessions.StartOptions, cmd sessions.ExecCommand) error {
+	return errors.New("fake: exec is not supported")
+}
+
+func (f *fakeRuntime) List(ctx context.Context) ([]sessions.RuntimeInfo, error) {
+	return nil, nil
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"nvimanywhere/internal/sessions"
+	"time"
+)
+
+// ============================================================
+// Session Events Handler
+// ------------------------------------------------------------
+// HandleSessionEvents streams status changes as server-sent
+// events while a session is being prepared:
+//
+//   GET /sessions/{token}/events
+//
+// Every event carries the same JSON as the status endpoint,
+// including clone progress. The stream ends once the session
+// is ready, failed or closed.
+// ============================================================
+
+const eventsHeartbeat = 15 * time.Second
+
+func (app *App) HandleSessionEvents(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+
+	app.mu.Lock()
+	sess := app.sessions[token]
+	app.mu.Unlock()
+	if sess == nil {
+		app.respondError(w, http.StatusNotFound, "session not found", nil)
+		return
+	}
+
+	// A large clone outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		app.respondError(w, http.StatusInternalServerError, "Streaming is not supported", err)
+		return
+	}
+
+	updates, unsubscribe := sess.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(st sessions.Status) bool {
+		data, err := json.Marshal(st)
+		if err != nil {
+			app.log.Error("Failed to encode status", "err", err)
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	// Subscribing first means nothing between this snapshot and the
+	// first update is lost.
+	st := sess.Status()
+	if !send(st) || streamDone(st.State) {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case st := <-updates:
+			if !send(st) || streamDone(st.State) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-app.ctx.Done():
+			return
+		}
+	}
+}
+
+// streamDone reports whether a state ends the preparation stream.
+func streamDone(st sessions.State) bool {
+	switch st {
+	case sessions.StateCreating, sessions.StateCloning, sessions.StateExtracting, sessions.StateStarting:
+		return false
+	default:
+		return true
+	}
+}
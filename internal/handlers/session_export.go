@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"nvimanywhere/internal/sessions"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// Session Export Handlers
+// ------------------------------------------------------------
+// A workspace is deleted with its session, so these let a user
+// take their work with them:
+//
+//   GET /sessions/{token}/workspace.tar.gz[?gitignore=1]
+//   GET /sessions/{token}/workspace.zip[?gitignore=1]
+//   GET /sessions/{token}/patch[?format=diff|mbox]
+//
+// gitignore=1 leaves out what the repo's .gitignore excludes.
+// The diff covers everything changed since the session started,
+// committed or not; mbox holds the new commits for git am.
+// ============================================================
+
+func (app *App) HandleExportArchive(w http.ResponseWriter, r *http.Request) {
+	format := sessions.ArchiveTarGz
+	contentType := "application/gzip"
+	if strings.HasSuffix(r.URL.Path, ".zip") {
+		format, contentType = sessions.ArchiveZip, "application/zip"
+	}
+	gitignore := r.URL.Query().Get("gitignore") == "1"
+
+	app.export(w, r, "workspace."+format, contentType, func(sess *sessions.Session, ew *exportWriter) error {
+		return sess.WriteArchive(r.Context(), ew, format, gitignore)
+	})
+}
+
+func (app *App) HandleExportPatch(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = sessions.PatchDiff
+	}
+	name := "workspace.patch"
+	if format == sessions.PatchMbox {
+		name = "workspace.mbox"
+	}
+
+	app.export(w, r, name, "text/x-patch; charset=utf-8", func(sess *sessions.Session, ew *exportWriter) error {
+		return sess.WritePatch(r.Context(), ew, format)
+	})
+}
+
+func (app *App) export(w http.ResponseWriter, r *http.Request, name, contentType string, write func(*sessions.Session, *exportWriter) error) {
+	token := r.PathValue("token")
+
+	app.mu.Lock()
+	sess := app.sessions[token]
+	app.mu.Unlock()
+	if sess == nil {
+		app.respondError(w, http.StatusNotFound, "session not found", nil)
+		return
+	}
+
+	// A large workspace takes longer than the server's write timeout.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		app.log.Warn("Failed to clear write deadline", "err", err)
+	}
+
+	ew := &exportWriter{w: w, name: name, contentType: contentType}
+	err := write(sess, ew)
+	if err == nil {
+		ew.start()
+		return
+	}
+	if ew.started {
+		// Headers are gone; all that is left is to cut the download short.
+		app.log.Error("Failed to export session", "token", token, "err", err)
+		panic(http.ErrAbortHandler)
+	}
+
+	switch {
+	case errors.Is(err, sessions.SessionRequestIsInvalid):
+		app.respondError(w, http.StatusBadRequest, err.Error(), err)
+	case errors.Is(err, sessions.SessionIsNotARepo),
+		errors.Is(err, sessions.SessionIsNotReady),
+		errors.Is(err, sessions.SessionIsFailed),
+		errors.Is(err, sessions.SessionIsClosed):
+		app.respondError(w, http.StatusConflict, err.Error(), err)
+	default:
+		app.respondError(w, http.StatusInternalServerError, "Failed to export session", err)
+	}
+}
+
+// exportWriter sends the download headers with the first byte, so errors
+// found before anything was written can still get a proper status.
+type exportWriter struct {
+	w           http.ResponseWriter
+	name        string
+	contentType string
+	started     bool
+}
+
+func (ew *exportWriter) start() {
+	if ew.started {
+		return
+	}
+	ew.started = true
+	h := ew.w.Header()
+	h.Set("Content-Type", ew.contentType)
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", ew.name))
+	h.Set("Cache-Control", "no-store")
+	ew.w.WriteHeader(http.StatusOK)
+}
+
+func (ew *exportWriter) Write(p []byte) (int, error) {
+	ew.start()
+	return ew.w.Write(p)
+}
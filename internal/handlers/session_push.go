@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"nvimanywhere/internal/httpjson"
+	"nvimanywhere/internal/sessions"
+	"time"
+)
+
+// ============================================================
+// Session Push Handler
+// ------------------------------------------------------------
+// HandleSessionPush commits the workspace and pushes it to a
+// new branch of the session's repository:
+//
+//   POST /sessions/{token}/git/push
+//   {"message": "...", "author_name": "...",
+//    "author_email": "...", "branch": "..."}
+//
+// The push uses the credentials the session was created with.
+// It answers with the branch and the commit that was pushed.
+// ============================================================
+
+const maxPushRequestBytes = 64 << 10
+
+func (app *App) HandleSessionPush(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+
+	app.mu.Lock()
+	sess := app.sessions[token]
+	app.mu.Unlock()
+	if sess == nil {
+		app.respondError(w, http.StatusNotFound, "session not found", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPushRequestBytes)
+	req, err := httpjson.Decode[sessions.PushRequest](r)
+	if err != nil {
+		app.respondError(w, http.StatusBadRequest, "Failed to process request", err)
+		return
+	}
+
+	// Committing and pushing a large change outlasts the write timeout.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		app.log.Warn("Failed to clear write deadline", "err", err)
+	}
+
+	res, err := sess.Push(r.Context(), req)
+	switch {
+	case err == nil:
+	case errors.Is(err, sessions.SessionRequestIsInvalid):
+		app.respondError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	case errors.Is(err, sessions.SessionIsNotARepo),
+		errors.Is(err, sessions.SessionHasNoChanges),
+		errors.Is(err, sessions.SessionIsNotReady),
+		errors.Is(err, sessions.SessionIsFailed),
+		errors.Is(err, sessions.SessionIsClosed):
+		app.respondError(w, http.StatusConflict, err.Error(), err)
+		return
+	case errors.Is(err, sessions.SessionPushIsRejected):
+		app.respondError(w, http.StatusBadGateway, err.Error(), err)
+		return
+	default:
+		app.respondError(w, http.StatusInternalServerError, "Failed to push", err)
+		return
+	}
+
+	if err := httpjson.Encode(w, http.StatusOK, res); err != nil {
+		app.log.Error("Failed to respond", "err", err)
+	}
+}
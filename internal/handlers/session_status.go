@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+	"nvimanywhere/internal/httpjson"
+)
+
+// ============================================================
+// Session Status Handler
+// ------------------------------------------------------------
+// HandleSessionStatus reports where a session is in its
+// lifecycle:
+//
+//   GET /sessions/{token}/status
+//
+// The shell UI follows /events instead and falls back to
+// polling this endpoint when EventSource is unavailable.
+// ============================================================
+
+func (app *App) HandleSessionStatus(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+
+	app.mu.Lock()
+	sess := app.sessions[token]
+	app.mu.Unlock()
+	if sess == nil {
+		app.respondError(w, http.StatusNotFound, "session not found", nil)
+		return
+	}
+
+	if err := httpjson.Encode(w, http.StatusOK, sess.Status()); err != nil {
+		app.log.Error("Failed to respond", "err", err)
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"nvimanywhere/internal/sessions"
+	"sync"
+	"time"
+)
+
+// ============================================================
+// Graceful Shutdown
+// ------------------------------------------------------------
+// Shutdown drains the gateway: new sessions are refused, the
+// session pool is emptied, attached clients get a "warning"
+// control message and session_runtime.shutdown.save_grace to
+// save (cut short once none is attached), then every session
+// is closed in parallel with "exit" sent to its client.
+// Sessions still closing when ctx ends are left to Reconcile
+// on the next start.
+// ============================================================
+
+func (app *App) Shutdown(ctx context.Context) error {
+	started := time.Now()
+	grace := app.cfg.SessionRuntime.Shutdown.SaveGrace
+
+	app.mu.Lock()
+	app.draining = true
+	live := make(map[string]*sessions.Session, len(app.sessions))
+	for token, sess := range app.sessions {
+		live[token] = sess
+	}
+	app.mu.Unlock()
+	// Cancelling app.ctx ends the sessions' contexts too, so it waits
+	// until they had their chance to close cleanly.
+	defer app.cancel()
+	app.pool.Close()
+
+	warned := 0
+	if grace > 0 {
+		for _, sess := range live {
+			if sess.Notify("warning", fmt.Sprintf("Server shuts down in %s: save your work", grace)) {
+				warned++
+			}
+		}
+	}
+	if warned > 0 {
+		app.log.Info("waiting for clients to save", "clients", warned, "grace", grace)
+		waitDetached(ctx, live, grace)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		closed int
+	)
+	for token, sess := range live {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess.Notify("exit", "Session closed: server shut down")
+			app.closeSession(token, sess)
+			mu.Lock()
+			closed++
+			mu.Unlock()
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	app.log.Info("sessions drained",
+		"sessions", len(live),
+		"closed", closed,
+		"warned", warned,
+		"elapsed", time.Since(started).Round(time.Millisecond),
+	)
+	if closed < len(live) {
+		return fmt.Errorf("%d of %d sessions did not close in time: %w", len(live)-closed, len(live), ctx.Err())
+	}
+	return nil
+}
+
+// waitDetached returns after grace, or once no session has a client
+// attached, whichever comes first.
+func waitDetached(ctx context.Context, live map[string]*sessions.Session, grace time.Duration) {
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline.C:
+			return
+		case <-ticker.C:
+		}
+		attached := false
+		for _, sess := range live {
+			attached = attached || sess.DetachedFor() == 0
+		}
+		if !attached {
+			return
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"nvimanywhere/internal/config"
+	"nvimanywhere/internal/registry"
+	"nvimanywhere/internal/sessions"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestApp serves an app on testRuntime whose clients get saveGrace to
+// save on shutdown.
+func newTestApp(t *testing.T, saveGrace time.Duration) (*App, *httptest.Server) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	yaml := fmt.Sprintf(`
+session_runtime:
+  runtime: local
+  base_path: %q
+  registry:
+    backend: memory
+  shutdown:
+    save_grace: %q
+  ws:
+    max_message_size: 32768
+    read_timeout: 5s
+    write_timeout: 1s
+    ping_interval: 1s
+`, filepath.Join(dir, "workspaces"), saveGrace)
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	app := InitApp(cfg, log, nil, registry.NewMemoryStore(), testRuntime, context.Background())
+	t.Cleanup(func() { app.Shutdown(context.Background()) })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions/new", app.HandleStartSession)
+	mux.HandleFunc("/sessions/", app.HandleSession)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return app, srv
+}
+
+// startSession starts a session through the API and waits until it is
+// ready.
+func startSession(t *testing.T, app *App, srv *httptest.Server) (string, *sessions.Session) {
+	t.Helper()
+	token, sess := bootSession(t, app, srv)
+	if st := sess.State(); st != sessions.StateReady {
+		t.Fatalf("session state = %s, want ready", st)
+	}
+	return token, sess
+}
+
+// bootSession starts a session through the API and waits until it is
+// done booting, whether that worked or not.
+func bootSession(t *testing.T, app *App, srv *httptest.Server) (string, *sessions.Session) {
+	t.Helper()
+	resp, err := http.Post(srv.URL+"/sessions/new", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /sessions/new = %d", resp.StatusCode)
+	}
+	var body struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	token := strings.TrimPrefix(body.Endpoint, "sessions/")
+
+	app.mu.Lock()
+	sess := app.sessions[token]
+	app.mu.Unlock()
+	<-sess.Booted()
+	return token, sess
+}
+
+// attach connects a client to the session and waits until it is attached.
+func attach(t *testing.T, srv *httptest.Server, token string, sess *sessions.Session) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/sessions/" + token
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	waitFor(t, "attach", func() bool { return sess.State() == sessions.StateAttached })
+	return conn
+}
+
+// readControl returns the next control message, skipping PTY output.
+func readControl(conn *websocket.Conn) (typ, reason string, err error) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			return "", "", err
+		}
+		if mt != websocket.TextMessage {
+			continue
+		}
+		var ctl struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		}
+		if err := json.Unmarshal(msg, &ctl); err != nil {
+			return "", "", err
+		}
+		return ctl.Type, ctl.Reason, nil
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestShutdownRefusesNewSessions(t *testing.T) {
+	app, srv := newTestApp(t, 0)
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(srv.URL+"/sessions/new", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("POST /sessions/new while draining = %d, want 503", resp.StatusCode)
+	}
+}
+
+func TestShutdownGraceEndsOnDetach(t *testing.T) {
+	const grace = 10 * time.Second
+	app, srv := newTestApp(t, grace)
+	token, sess := startSession(t, app, srv)
+	conn := attach(t, srv, token, sess)
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- app.Shutdown(context.Background()) }()
+
+	if typ, _, err := readControl(conn); err != nil || typ != "warning" {
+		t.Fatalf("control = %q, %v, want a warning", typ, err)
+	}
+	conn.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(grace / 2):
+		t.Fatal("Shutdown still waits after the client detached")
+	}
+	if elapsed := time.Since(started); elapsed >= grace {
+		t.Fatalf("Shutdown took %v, the full grace", elapsed)
+	}
+	if st := sess.State(); st != sessions.StateClosed {
+		t.Fatalf("session state = %s, want closed", st)
+	}
+}
+
+func TestShutdownClosesAttachedSessionsAfterGrace(t *testing.T) {
+	const grace = 500 * time.Millisecond
+	app, srv := newTestApp(t, grace)
+	token, sess := startSession(t, app, srv)
+	conn := attach(t, srv, token, sess)
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- app.Shutdown(context.Background()) }()
+
+	if typ, _, err := readControl(conn); err != nil || typ != "warning" {
+		t.Fatalf("control = %q, %v, want a warning", typ, err)
+	}
+	typ, reason, err := readControl(conn)
+	if err != nil || typ != "exit" || reason != "Session closed: server shut down" {
+		t.Fatalf("control = %q %q, %v, want exit for the shutdown", typ, reason, err)
+	}
+	if time.Since(started) < grace {
+		t.Fatal("session closed before the grace was over")
+	}
+	// The closed editor must not be reported as exited on its own.
+	if typ, reason, err := readControl(conn); err == nil {
+		t.Fatalf("control = %q %q after exit, want the connection closed", typ, reason)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdownGivesUpAtDeadline(t *testing.T) {
+	app, srv := newTestApp(t, 0)
+	startSession(t, app, srv)
+	testRuntime.holdTerminate(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err := app.Shutdown(ctx)
+	if err == nil || !strings.Contains(err.Error(), "1 of 1 sessions did not close in time") {
+		t.Fatalf("Shutdown = %v, want the session reported as still closing", err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("Shutdown took %v past its deadline", elapsed)
+	}
+}
@@ -1,56 +1,195 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"nvimanywhere/internal/config"
 	"nvimanywhere/internal/httpjson"
 	"nvimanywhere/internal/sessions"
+	"nvimanywhere/internal/workspaces"
+	"os"
 	"time"
 )
 
+// Labels that tie a session's containers to its owner and the named
+// workspace it has open, so Reconcile can mark it in use again after a
+// restart.
+const (
+	labelOwner         = "nvimanywhere.owner"
+	labelWorkspaceName = "nvimanywhere.workspace-name"
+)
+
+type startRequest struct {
+	Repo   string   `json:"repo"`
+	Ref    string   `json:"ref"`
+	Depth  int      `json:"depth"`
+	Sparse []string `json:"sparse"`
+	// Credentials are passed on for the clone and never logged.
+	Credentials *sessions.Credentials `json:"credentials"`
+	Resources   *config.Resources     `json:"resources"`
+	Network     string                `json:"network"`
+	// Workspace names a workspace of the caller's to open, created on
+	// first use, instead of a throwaway one.
+	Workspace string `json:"workspace"`
+}
+
+// ============================================================
+// Start Session Handler
+// ------------------------------------------------------------
+// HandleStartSession creates a session from a JSON body, or
+// from a multipart/form-data upload with the same JSON in an
+// "options" field and a .tar.gz, .tar or .zip in "archive"
+// that seeds the workspace instead of a clone.
+//
+// With "workspace" set the session opens the caller's named
+// workspace. A new one is seeded like any other; one that
+// already has files is opened as it was left.
+// ============================================================
+
 func (app *App) HandleStartSession(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		app.respondError(w, http.StatusMethodNotAllowed, "Method not allowd", nil)
 		return
 	}
-	type request struct {
-		Repo string `json:"repo"`
+	app.mu.Lock()
+	draining := app.draining
+	app.mu.Unlock()
+	if draining {
+		app.respondError(w, http.StatusServiceUnavailable, "Server is shutting down", nil)
+		return
 	}
 
-	data, err := httpjson.Decode[request](r)
+	var (
+		data    startRequest
+		archive string
+		err     error
+	)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		data, archive, err = app.receiveUpload(w, r)
+		if errors.Is(err, errUploadTooLarge) {
+			app.respondError(w, http.StatusRequestEntityTooLarge, err.Error(), err)
+			return
+		}
+	} else {
+		data, err = httpjson.Decode[startRequest](r)
+	}
 	if err != nil {
 		app.respondError(w, http.StatusBadRequest, "Failed to process request", err)
 		return
 	}
-	token, err := createToken()
-	if err != nil {
-		app.respondError(w, 500, "Failed to create token", err)
-		return
+	// The session owns the archive once it has been created.
+	created := false
+	defer func() {
+		if archive != "" && !created {
+			os.Remove(archive)
+		}
+	}()
+
+	var (
+		named   workspaces.Workspace
+		release func()
+		labels  = make(map[string]string)
+	)
+	// The owner is recorded for every session that has one.
+	owner, hasOwner := app.owner(r)
+	if hasOwner {
+		labels[labelOwner] = owner
+	}
+	repo := data.Repo
+	if data.Workspace != "" {
+		if !hasOwner {
+			app.respondError(w, http.StatusUnauthorized, "Named workspaces need an authenticated user", nil)
+			return
+		}
+		named, release, err = app.workspaces.Open(owner, data.Workspace)
+		if err != nil {
+			app.respondWorkspaceError(w, err)
+			return
+		}
+		defer func() {
+			if !created {
+				release()
+			}
+		}()
+		if repo != "" && named.Repo != "" && repo != named.Repo {
+			app.respondError(w, http.StatusBadRequest, "Workspace was cloned from another repo", nil)
+			return
+		}
+		if repo == "" && archive == "" {
+			// Pushes from a reopened workspace go where it was cloned from.
+			repo = named.Repo
+		}
+		labels[labelWorkspaceName] = data.Workspace
 	}
 
-	s, err := sessions.StartNewSession(app.ctx, app.cfg.SessionRuntime, data.Repo, token)
+	opts := sessions.Options{
+		Repo:        repo,
+		Ref:         data.Ref,
+		Depth:       data.Depth,
+		Sparse:      data.Sparse,
+		Credentials: data.Credentials,
+		Archive:     archive,
+		Resources:   data.Resources,
+		Network:     data.Network,
+		Workspace:   named.Path,
+		Labels:      labels,
+	}
+	// A pooled session is already running; anything else starts cold.
+	s := app.pool.Take(opts)
+	var token string
+	if s != nil {
+		token = s.Token()
+	} else {
+		token, err = sessions.NewToken()
+		if err != nil {
+			app.respondError(w, 500, "Failed to create token", err)
+			return
+		}
+		s, err = sessions.StartNewSession(app.ctx, app.runtime, app.cfg.SessionRuntime, token, opts)
+	}
+	if errors.Is(err, sessions.SessionRequestIsInvalid) {
+		app.respondError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
 	if err != nil {
 		app.respondError(w, 500, "Failed to creat session", err)
 		return
 	}
+	created = true
+	if data.Workspace != "" && named.Repo == "" && data.Repo != "" {
+		if err := app.workspaces.SetRepo(owner, data.Workspace, data.Repo); err != nil {
+			app.log.Error("Failed to record workspace repo", "err", err)
+		}
+	}
 	app.mu.Lock()
+	if app.draining {
+		// Shutdown has already taken its list of sessions to close.
+		app.mu.Unlock()
+		s.Close()
+		if release != nil {
+			release()
+		}
+		app.respondError(w, http.StatusServiceUnavailable, "Server is shutting down", nil)
+		return
+	}
 	app.sessions[token] = s
+	if release != nil {
+		app.releases[token] = release
+	}
 	app.mu.Unlock()
+	go app.track(token, s)
+	go app.watchBoot(token, s)
 
 	endpoint := "sessions/" + token
-	if err := httpjson.Encode(w, 201, map[string]string{"endpoint": endpoint}); err != nil {
+	if err := httpjson.Encode(w, 201, map[string]string{
+		"endpoint": endpoint,
+		"status":   endpoint + "/status",
+		"events":   endpoint + "/events",
+	}); err != nil {
 		app.respondError(w, 500, "Failed to respond", err)
 		return
 	}
 	app.log.Info(fmt.Sprintf("Sesion Started at: %v", time.Now()))
 }
-
-func createToken() (string, error) {
-	b := make([]byte, 8)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
-	}
-	return base64.RawURLEncoding.EncodeToString(b), nil
-}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+var errUploadTooLarge = errors.New("Archive is larger than the upload limit")
+
+// maxOptionsSize bounds the JSON "options" field of an upload.
+const maxOptionsSize = 64 * 1024
+
+// receiveUpload reads a multipart session request. The archive is spooled
+// to a hidden file in the workspaces dir, which the caller removes unless a
+// session takes it over.
+func (app *App) receiveUpload(w http.ResponseWriter, r *http.Request) (startRequest, string, error) {
+	var data startRequest
+	uploads := app.cfg.SessionRuntime.Uploads
+	maxArchive := uploads.ArchiveBytes()
+
+	// An upload outlives the server's read and write timeouts.
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(uploads.Timeout)
+	rc.SetReadDeadline(deadline)
+	rc.SetWriteDeadline(deadline)
+	r.Body = http.MaxBytesReader(w, r.Body, maxArchive+maxOptionsSize+64*1024)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return data, "", err
+	}
+
+	archive := ""
+	fail := func(err error) (startRequest, string, error) {
+		if archive != "" {
+			os.Remove(archive)
+		}
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			err = errUploadTooLarge
+		}
+		return startRequest{}, "", err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		switch part.FormName() {
+		case "options":
+			if err := json.NewDecoder(io.LimitReader(part, maxOptionsSize)).Decode(&data); err != nil {
+				return fail(fmt.Errorf("Decode options: %w", err))
+			}
+		case "archive":
+			if archive != "" {
+				return fail(errors.New("Only one archive can be uploaded"))
+			}
+			f, err := os.CreateTemp(app.cfg.SessionRuntime.BasePath, ".upload-*")
+			if err != nil {
+				return fail(err)
+			}
+			archive = f.Name()
+			n, err := io.Copy(f, io.LimitReader(part, maxArchive+1))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return fail(err)
+			}
+			if n > maxArchive {
+				return fail(errUploadTooLarge)
+			}
+		}
+		part.Close()
+	}
+
+	if archive == "" {
+		return fail(errors.New("Upload has no archive"))
+	}
+	return data, archive, nil
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"nvimanywhere/internal/httpjson"
+	"nvimanywhere/internal/workspaces"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// Named Workspace Handlers
+// ------------------------------------------------------------
+// Named workspaces outlive their sessions and belong to the
+// user an authenticating proxy puts in the owner header:
+//
+//   GET    /workspaces
+//   PATCH  /workspaces/{name}   {"name": "new-name"}
+//   DELETE /workspaces/{name}
+//
+// Sessions open them with "workspace" in POST /sessions/new.
+// A workspace open in a session can't be renamed or deleted.
+// ============================================================
+
+func (app *App) HandleListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	owner, ok := app.owner(r)
+	if !ok {
+		app.respondError(w, http.StatusUnauthorized, "Named workspaces need an authenticated user", nil)
+		return
+	}
+	list, err := app.workspaces.List(owner)
+	if err != nil {
+		app.respondWorkspaceError(w, err)
+		return
+	}
+	if err := httpjson.Encode(w, http.StatusOK, list); err != nil {
+		app.log.Error("Failed to respond", "err", err)
+	}
+}
+
+type renameRequest struct {
+	Name string `json:"name"`
+}
+
+func (app *App) HandleRenameWorkspace(w http.ResponseWriter, r *http.Request) {
+	owner, ok := app.owner(r)
+	if !ok {
+		app.respondError(w, http.StatusUnauthorized, "Named workspaces need an authenticated user", nil)
+		return
+	}
+	data, err := httpjson.Decode[renameRequest](r)
+	if err != nil {
+		app.respondError(w, http.StatusBadRequest, "Failed to process request", err)
+		return
+	}
+	ws, err := app.workspaces.Rename(owner, r.PathValue("name"), data.Name)
+	if err != nil {
+		app.respondWorkspaceError(w, err)
+		return
+	}
+	if err := httpjson.Encode(w, http.StatusOK, ws); err != nil {
+		app.log.Error("Failed to respond", "err", err)
+	}
+}
+
+func (app *App) HandleDeleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	owner, ok := app.owner(r)
+	if !ok {
+		app.respondError(w, http.StatusUnauthorized, "Named workspaces need an authenticated user", nil)
+		return
+	}
+	if err := app.workspaces.Delete(owner, r.PathValue("name")); err != nil {
+		app.respondWorkspaceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// owner is the user named by the configured header, or the default owner.
+// Only a trusted proxy can name the user: any client can set the header.
+func (app *App) owner(r *http.Request) (string, bool) {
+	cfg := app.cfg.SessionRuntime.Workspaces
+	if owner := strings.TrimSpace(r.Header.Get(cfg.OwnerHeader)); owner != "" && cfg.TrustsProxy(r.RemoteAddr) {
+		return owner, true
+	}
+	return cfg.DefaultOwner, cfg.DefaultOwner != ""
+}
+
+func (app *App) respondWorkspaceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, workspaces.WorkspaceIsNotFound):
+		app.respondError(w, http.StatusNotFound, err.Error(), err)
+	case errors.Is(err, workspaces.WorkspaceNameIsInvalid):
+		app.respondError(w, http.StatusBadRequest, err.Error(), err)
+	case errors.Is(err, workspaces.WorkspaceIsInUse),
+		errors.Is(err, workspaces.WorkspaceAlreadyExists),
+		errors.Is(err, workspaces.WorkspaceLimitIsReached):
+		app.respondError(w, http.StatusConflict, err.Error(), err)
+	default:
+		app.respondError(w, http.StatusInternalServerError, "Failed to manage workspace", err)
+	}
+}
+
+// pruneWorkspaces applies the retention policy until the app shuts down.
+func (app *App) pruneWorkspaces(retention time.Duration) {
+	// Checking more often than every hour buys nothing at day scales.
+	every := min(retention, time.Hour)
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		n, err := app.workspaces.Prune(time.Now().Add(-retention))
+		if err != nil {
+			app.log.Error("Failed to prune workspaces", "err", err)
+		}
+		if n > 0 {
+			app.log.Info("pruned unused workspaces", "count", n, "retention", retention)
+		}
+		select {
+		case <-app.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"nvimanywhere/internal/config"
+	"testing"
+)
+
+func TestOwnerHeaderNeedsTrustedProxy(t *testing.T) {
+	for name, tt := range map[string]struct {
+		from, header, fallback string
+		want                   string
+		ok                     bool
+	}{
+		"trusted proxy":            {from: "10.0.0.2:5000", header: "alice", want: "alice", ok: true},
+		"untrusted client":         {from: "192.0.2.1:5000", header: "alice"},
+		"untrusted with a default": {from: "192.0.2.1:5000", header: "alice", fallback: "guest", want: "guest", ok: true},
+		"trusted without header":   {from: "10.0.0.2:5000"},
+	} {
+		app := &App{cfg: &config.Config{SessionRuntime: &config.SessionRuntime{Workspaces: &config.Workspaces{
+			OwnerHeader:    "X-Forwarded-User",
+			TrustedProxies: []string{"10.0.0.0/24"},
+			DefaultOwner:   tt.fallback,
+		}}}}
+		r := httptest.NewRequest("GET", "/workspaces", nil)
+		r.RemoteAddr = tt.from
+		if tt.header != "" {
+			r.Header.Set("X-Forwarded-User", tt.header)
+		}
+		if owner, ok := app.owner(r); owner != tt.want || ok != tt.ok {
+			t.Errorf("%s: owner = %q, %v; want %q, %v", name, owner, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -6,8 +6,6 @@ import (
 	"net/http"
 )
 
-
-
 func Encode[T any](w http.ResponseWriter, status int, v T) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -34,7 +34,7 @@ func NewLogger(cfg *config.Config) (*slog.Logger, func() error, error) {
 		"instance", hostName,
 		"pid", os.Getpid(),
 		"listen_addr", cfg.HTTP.Host+":"+cfg.HTTP.Port,
-		"container_runtime", "docker",
+		"container_runtime", cfg.SessionRuntime.Runtime,
 	)
 
 	return log, closeFn, nil
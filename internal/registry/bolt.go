@@ -0,0 +1,125 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var sessionsBucket = []byte("sessions")
+
+// BoltStore keeps records in a bbolt file, one JSON value per token.
+// Only one process can have the file open; a second gateway on the same
+// path fails to start instead of sharing it.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("Failed to create session registry dir: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open session registry %q: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to open session registry %q: %w", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (b *BoltStore) Put(r Record) error {
+	if r.Token == "" {
+		return errors.New("Session record has no token")
+	}
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(r.Token), v)
+	})
+}
+
+func (b *BoltStore) Get(token string) (Record, error) {
+	var r Record
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(sessionsBucket).Get([]byte(token))
+		if v == nil {
+			return RecordIsNotFound
+		}
+		return json.Unmarshal(v, &r)
+	})
+	return r, err
+}
+
+func (b *BoltStore) List() ([]Record, error) {
+	var out []Record
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(k, v []byte) error {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("Failed to read session record %q: %w", k, err)
+			}
+			out = append(out, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortRecords(out)
+	return out, nil
+}
+
+func (b *BoltStore) Delete(token string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionsBucket)
+		if bucket.Get([]byte(token)) == nil {
+			return RecordIsNotFound
+		}
+		return bucket.Delete([]byte(token))
+	})
+}
+
+func (b *BoltStore) Prune(cutoff time.Time) (int, error) {
+	n := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionsBucket)
+		var stale [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var r Record
+			// Unreadable records are left for someone to look at.
+			if json.Unmarshal(v, &r) == nil && expired(r, cutoff) {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(stale)
+		return nil
+	})
+	return n, err
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
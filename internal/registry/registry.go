@@ -0,0 +1,139 @@
+// Package registry records every session the gateway starts: who owns it,
+// where it runs and what state it is in. Records outlive the session and,
+// with the bolt backend, the gateway process, so sessions can be found
+// again after a restart, listed and audited.
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"nvimanywhere/internal/config"
+	"sort"
+	"sync"
+	"time"
+)
+
+var RecordIsNotFound = errors.New("Session record is not found")
+
+// Record is what the registry knows about one session.
+type Record struct {
+	Token       string `json:"token"`
+	Owner       string `json:"owner,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+	Workspace   string `json:"workspace"`
+	// Repo never holds credentials; see sessions.RedactRepo.
+	Repo      string    `json:"repo,omitempty"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// ClosedAt is zero while the session is alive.
+	ClosedAt time.Time `json:"closed_at,omitzero"`
+}
+
+// SessionStore keeps session records by token. Implementations are safe
+// for concurrent use.
+type SessionStore interface {
+	// Put creates or replaces the record of r.Token.
+	Put(r Record) error
+	Get(token string) (Record, error)
+	// List returns every record, oldest first.
+	List() ([]Record, error)
+	Delete(token string) error
+	// Prune deletes records of sessions closed before cutoff and returns
+	// how many it deleted.
+	Prune(cutoff time.Time) (int, error)
+	Close() error
+}
+
+// Open returns the store cfg selects.
+func Open(cfg *config.Registry) (SessionStore, error) {
+	switch cfg.Backend {
+	case config.RegistryMemory:
+		return NewMemoryStore(), nil
+	case config.RegistryBolt:
+		return OpenBoltStore(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown session registry backend %q", cfg.Backend)
+	}
+}
+
+// MemoryStore keeps records for the life of the process only.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (m *MemoryStore) Put(r Record) error {
+	if r.Token == "" {
+		return errors.New("Session record has no token")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[r.Token] = r
+	return nil
+}
+
+func (m *MemoryStore) Get(token string) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.records[token]
+	if !ok {
+		return Record{}, RecordIsNotFound
+	}
+	return r, nil
+}
+
+func (m *MemoryStore) List() ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Record, 0, len(m.records))
+	for _, r := range m.records {
+		out = append(out, r)
+	}
+	sortRecords(out)
+	return out, nil
+}
+
+func (m *MemoryStore) Delete(token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.records[token]; !ok {
+		return RecordIsNotFound
+	}
+	delete(m.records, token)
+	return nil
+}
+
+func (m *MemoryStore) Prune(cutoff time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for token, r := range m.records {
+		if expired(r, cutoff) {
+			delete(m.records, token)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func expired(r Record, cutoff time.Time) bool {
+	return !r.ClosedAt.IsZero() && r.ClosedAt.Before(cutoff)
+}
+
+func sortRecords(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].CreatedAt.Before(records[j].CreatedAt)
+		}
+		return records[i].Token < records[j].Token
+	})
+}
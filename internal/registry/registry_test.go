@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// stores runs a test against every backend.
+func stores(t *testing.T, test func(t *testing.T, s SessionStore)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	t.Run("bolt", func(t *testing.T) {
+		s, err := OpenBoltStore(filepath.Join(t.TempDir(), "sessions.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		test(t, s)
+	})
+}
+
+func testRecord(token string, created time.Time) Record {
+	return Record{
+		Token:       token,
+		Owner:       "ada@example.com",
+		ContainerID: "c-" + token,
+		Workspace:   "/workspaces/" + token,
+		Repo:        "https://example.com/repo.git",
+		State:       "ready",
+		CreatedAt:   created,
+		UpdatedAt:   created,
+	}
+}
+
+func TestPutGetListDelete(t *testing.T) {
+	stores(t, func(t *testing.T, s SessionStore) {
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		for i, token := range []string{"second", "first"} {
+			if err := s.Put(testRecord(token, now.Add(-time.Duration(i)*time.Minute))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rec := testRecord("second", now)
+		rec.State = "attached"
+		if err := s.Put(rec); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := s.Get("second")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.State != "attached" || !got.CreatedAt.Equal(now) || got.Owner != rec.Owner {
+			t.Fatalf("record = %+v, want %+v", got, rec)
+		}
+		list, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 || list[0].Token != "first" || list[1].Token != "second" {
+			t.Fatalf("list = %+v, want first then second", list)
+		}
+
+		if err := s.Delete("first"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Get("first"); !errors.Is(err, RecordIsNotFound) {
+			t.Fatalf("get deleted: err = %v, want RecordIsNotFound", err)
+		}
+		if err := s.Delete("first"); !errors.Is(err, RecordIsNotFound) {
+			t.Fatalf("delete twice: err = %v, want RecordIsNotFound", err)
+		}
+		if err := s.Put(Record{}); err == nil {
+			t.Fatal("record without a token was stored")
+		}
+	})
+}
+
+func TestPruneDropsOnlyOldClosedRecords(t *testing.T) {
+	stores(t, func(t *testing.T, s SessionStore) {
+		now := time.Now()
+		old := testRecord("old", now.Add(-48*time.Hour))
+		old.ClosedAt = now.Add(-47 * time.Hour)
+		recent := testRecord("recent", now.Add(-2*time.Hour))
+		recent.ClosedAt = now.Add(-time.Hour)
+		open := testRecord("open", now.Add(-72*time.Hour))
+		for _, r := range []Record{old, recent, open} {
+			if err := s.Put(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		n, err := s.Prune(now.Add(-24 * time.Hour))
+		if err != nil || n != 1 {
+			t.Fatalf("pruned %d, err = %v, want 1", n, err)
+		}
+		list, _ := s.List()
+		if len(list) != 2 || list[0].Token != "open" || list[1].Token != "recent" {
+			t.Fatalf("list = %+v, want open and recent", list)
+		}
+	})
+}
+
+func TestBoltStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	s, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(testRecord("kept", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, err := s.Get("kept"); err != nil || got.ContainerID != "c-kept" {
+		t.Fatalf("reopened record = %+v, err = %v", got, err)
+	}
+}
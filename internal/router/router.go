@@ -22,6 +22,15 @@ func AddRoutes(mux *http.ServeMux, h *handlers.App) error {
 	mux.HandleFunc("/health", h.HandleHealth)
 	mux.HandleFunc("/", h.HandleIndex)
 	mux.HandleFunc("/sessions/new", h.HandleStartSession)
+	mux.HandleFunc("GET /sessions/{token}/status", h.HandleSessionStatus)
+	mux.HandleFunc("GET /sessions/{token}/events", h.HandleSessionEvents)
+	mux.HandleFunc("GET /sessions/{token}/workspace.tar.gz", h.HandleExportArchive)
+	mux.HandleFunc("GET /sessions/{token}/workspace.zip", h.HandleExportArchive)
+	mux.HandleFunc("GET /sessions/{token}/patch", h.HandleExportPatch)
+	mux.HandleFunc("POST /sessions/{token}/git/push", h.HandleSessionPush)
 	mux.HandleFunc("/sessions/", h.HandleSession)
+	mux.HandleFunc("GET /workspaces", h.HandleListWorkspaces)
+	mux.HandleFunc("PATCH /workspaces/{name}", h.HandleRenameWorkspace)
+	mux.HandleFunc("DELETE /workspaces/{name}", h.HandleDeleteWorkspace)
 	return nil
 }
@@ -0,0 +1,290 @@
+package sessions
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// archiveLimits bound what an uploaded archive may expand to.
+type archiveLimits struct {
+	maxBytes int64
+	maxFiles int
+}
+
+var (
+	errArchiveTooLarge   = errors.New("Archive expands beyond the size limit")
+	errArchiveTooMany    = errors.New("Archive has too many entries")
+	errArchiveUnknown    = errors.New("Archive is not a .tar.gz, .tar or .zip file")
+	errArchiveEntryPath  = errors.New("Archive entry escapes the workspace")
+	errArchiveLinkTarget = errors.New("Archive symlink points outside the workspace")
+)
+
+// extractArchive unpacks the archive at src into dest. The format is
+// sniffed from the content rather than trusted from the file name.
+func extractArchive(src, dest string, limits archiveLimits) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	dest, err = filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	x := &extractor{dest: dest, limits: limits, links: make(map[string]string)}
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return fmt.Errorf("Failed to read archive: %w", err)
+		}
+		defer gz.Close()
+		if err := x.tar(tar.NewReader(gz)); err != nil {
+			return err
+		}
+	case len(head) > 262 && string(head[257:262]) == "ustar":
+		if err := x.tar(tar.NewReader(bufio.NewReader(f))); err != nil {
+			return err
+		}
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return fmt.Errorf("Failed to read archive: %w", err)
+		}
+		if err := x.zip(zr); err != nil {
+			return err
+		}
+	default:
+		return errArchiveUnknown
+	}
+	return x.createLinks()
+}
+
+// extractor writes entries below dest. Symlinks are created only after
+// every regular file is written, so no entry can be written through one.
+type extractor struct {
+	dest    string
+	limits  archiveLimits
+	entries int
+	written int64
+	// links maps a link's path relative to dest to its target.
+	links map[string]string
+}
+
+func (x *extractor) tar(tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to read archive: %w", err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.dir(hdr.Name)
+		case tar.TypeReg, tar.TypeRegA:
+			err = x.file(hdr.Name, fs.FileMode(hdr.Mode), tr)
+		case tar.TypeSymlink:
+			err = x.symlink(hdr.Name, hdr.Linkname)
+		case tar.TypeLink:
+			err = fmt.Errorf("Archive entry %q is a hard link, which is not supported", hdr.Name)
+		default:
+			// Devices, fifos and pax metadata have no place in a workspace.
+			err = x.count()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (x *extractor) zip(zr *zip.Reader) error {
+	for _, f := range zr.File {
+		mode := f.Mode()
+		var err error
+		switch {
+		case mode.IsDir():
+			err = x.dir(f.Name)
+		case mode&fs.ModeSymlink != 0:
+			err = x.zipSymlink(f)
+		case mode.IsRegular():
+			err = x.zipFile(f, mode)
+		default:
+			err = x.count()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) zipFile(f *zip.File, mode fs.FileMode) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("Failed to read archive entry %q: %w", f.Name, err)
+	}
+	defer rc.Close()
+	return x.file(f.Name, mode, rc)
+}
+
+// zipSymlink reads the link target, which zip stores as the entry's content.
+func (x *extractor) zipSymlink(f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("Failed to read archive entry %q: %w", f.Name, err)
+	}
+	defer rc.Close()
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return fmt.Errorf("Failed to read archive entry %q: %w", f.Name, err)
+	}
+	return x.symlink(f.Name, string(target))
+}
+
+func (x *extractor) count() error {
+	x.entries++
+	if x.entries > x.limits.maxFiles {
+		return errArchiveTooMany
+	}
+	return nil
+}
+
+// resolve maps an entry name to a clean path relative to dest, rejecting
+// absolute names, drive letters and anything that climbs out with "..".
+func (x *extractor) resolve(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || strings.Contains(name, ":") || strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("%w: %q", errArchiveEntryPath, name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %q", errArchiveEntryPath, name)
+		}
+	}
+	rel := path.Clean(name)
+	if rel == "." {
+		return "", nil
+	}
+	// Nothing may be written below a symlink from the archive either.
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if _, ok := x.links[dir]; ok {
+			return "", fmt.Errorf("%w: %q is below a symlink", errArchiveEntryPath, name)
+		}
+	}
+	return rel, nil
+}
+
+func (x *extractor) dir(name string) error {
+	if err := x.count(); err != nil {
+		return err
+	}
+	rel, err := x.resolve(name)
+	if err != nil || rel == "" {
+		return err
+	}
+	return os.MkdirAll(filepath.Join(x.dest, filepath.FromSlash(rel)), 0o755)
+}
+
+func (x *extractor) file(name string, mode fs.FileMode, r io.Reader) error {
+	if err := x.count(); err != nil {
+		return err
+	}
+	rel, err := x.resolve(name)
+	if err != nil || rel == "" {
+		return err
+	}
+	full := filepath.Join(x.dest, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return err
+	}
+
+	perm := fs.FileMode(0o644)
+	if mode&0o111 != 0 {
+		perm = 0o755
+	}
+	out, err := os.OpenFile(full, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	remaining := x.limits.maxBytes - x.written
+	n, err := io.Copy(out, io.LimitReader(r, remaining+1))
+	x.written += n
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to extract %q: %w", name, err)
+	}
+	if n > remaining {
+		return errArchiveTooLarge
+	}
+	return nil
+}
+
+// symlink records a link to create once all files are written. Targets
+// must be relative and stay inside dest.
+func (x *extractor) symlink(name, target string) error {
+	if err := x.count(); err != nil {
+		return err
+	}
+	rel, err := x.resolve(name)
+	if err != nil || rel == "" {
+		return err
+	}
+	if target == "" || strings.HasPrefix(target, "/") || strings.ContainsAny(target, "\\:\x00") {
+		return fmt.Errorf("%w: %q -> %q", errArchiveLinkTarget, name, target)
+	}
+	resolved := path.Join(path.Dir(rel), target)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("%w: %q -> %q", errArchiveLinkTarget, name, target)
+	}
+	// The cleaned target only has leading "..", which start from a real
+	// directory. Left as is, "l/../x" would climb out if l is a link to ".".
+	target = path.Clean(target)
+	for link := range x.links {
+		if strings.HasPrefix(link, rel+"/") {
+			return fmt.Errorf("%w: %q is above another symlink", errArchiveEntryPath, name)
+		}
+	}
+	x.links[rel] = target
+	return nil
+}
+
+func (x *extractor) createLinks() error {
+	for rel, target := range x.links {
+		full := filepath.Join(x.dest, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			return err
+		}
+		if err := os.Symlink(target, full); err != nil {
+			return fmt.Errorf("Failed to extract %q: %w", rel, err)
+		}
+	}
+	return nil
+}
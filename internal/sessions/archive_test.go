@@ -0,0 +1,186 @@
+package sessions
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// entry is an archive member; link makes it a symlink.
+type entry struct {
+	name, body, link string
+	dir              bool
+}
+
+func writeTarGz(t *testing.T, entries []entry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0o755, 0
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(e.body))
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return writeArchive(t, buf.Bytes())
+}
+
+func writeZip(t *testing.T, entries []entry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		body := e.body
+		switch {
+		case e.dir:
+			hdr.SetMode(fs.ModeDir | 0o755)
+		case e.link != "":
+			hdr.SetMode(fs.ModeSymlink | 0o777)
+			body = e.link
+		default:
+			hdr.SetMode(0o644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	zw.Close()
+	return writeArchive(t, buf.Bytes())
+}
+
+func writeArchive(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var testLimits = archiveLimits{maxBytes: 1 << 20, maxFiles: 100}
+
+func TestExtractArchive(t *testing.T) {
+	entries := []entry{
+		{name: "project/", dir: true},
+		{name: "project/main.go", body: "package main\n"},
+		{name: "project/docs/README.md", body: "docs\n"},
+		{name: "project/README", link: "docs/README.md"},
+	}
+	for name, write := range map[string]func(*testing.T, []entry) string{"tar.gz": writeTarGz, "zip": writeZip} {
+		t.Run(name, func(t *testing.T) {
+			dest := t.TempDir()
+			if err := extractArchive(write(t, entries), dest, testLimits); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(filepath.Join(dest, "project", "README"))
+			if err != nil || string(data) != "docs\n" {
+				t.Fatalf("symlinked README = %q, %v", data, err)
+			}
+			if _, err := os.Stat(filepath.Join(dest, "project", "main.go")); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestExtractArchiveRejectsEscapes(t *testing.T) {
+	tests := map[string][]entry{
+		"zip slip":            {{name: "../evil", body: "x"}},
+		"nested zip slip":     {{name: "a/../../evil", body: "x"}},
+		"absolute":            {{name: "/tmp/evil", body: "x"}},
+		"windows path":        {{name: "..\\evil", body: "x"}},
+		"absolute link":       {{name: "l", link: "/etc"}},
+		"escaping link":       {{name: "a/l", link: "../../etc"}},
+		"write through link":  {{name: "l", link: "sub"}, {name: "l/evil", body: "x"}},
+		"link above link":     {{name: "a/l", link: "x"}, {name: "a", link: "."}},
+		"link through a link": {{name: "a", link: "."}, {name: "a/l", link: "../etc"}},
+	}
+	for name, entries := range tests {
+		for format, write := range map[string]func(*testing.T, []entry) string{"tar.gz": writeTarGz, "zip": writeZip} {
+			dest := filepath.Join(t.TempDir(), "ws")
+			os.Mkdir(dest, 0o755)
+			err := extractArchive(write(t, entries), dest, testLimits)
+			if !errors.Is(err, errArchiveEntryPath) && !errors.Is(err, errArchiveLinkTarget) {
+				t.Errorf("%s (%s): err = %v, want a path error", name, format, err)
+			}
+			if _, err := os.Lstat(filepath.Join(filepath.Dir(dest), "evil")); err == nil {
+				t.Errorf("%s (%s): file written outside the workspace", name, format)
+			}
+		}
+	}
+}
+
+func TestExtractArchiveCleansLinkTargets(t *testing.T) {
+	dest := t.TempDir()
+	err := extractArchive(writeTarGz(t, []entry{
+		{name: "sub/file", body: "x"},
+		{name: "l", link: "sub/../sub/file"},
+	}), dest, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target, _ := os.Readlink(filepath.Join(dest, "l")); target != "sub/file" {
+		t.Fatalf("link target = %q, want the cleaned path", target)
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	big := writeTarGz(t, []entry{{name: "big", body: string(make([]byte, 2048))}})
+	if err := extractArchive(big, t.TempDir(), archiveLimits{maxBytes: 1024, maxFiles: 10}); !errors.Is(err, errArchiveTooLarge) {
+		t.Fatalf("err = %v, want errArchiveTooLarge", err)
+	}
+
+	many := writeZip(t, []entry{{name: "a"}, {name: "b"}, {name: "c"}})
+	if err := extractArchive(many, t.TempDir(), archiveLimits{maxBytes: 1024, maxFiles: 2}); !errors.Is(err, errArchiveTooMany) {
+		t.Fatalf("err = %v, want errArchiveTooMany", err)
+	}
+
+	junk := writeArchive(t, []byte("not an archive"))
+	if err := extractArchive(junk, t.TempDir(), testLimits); !errors.Is(err, errArchiveUnknown) {
+		t.Fatalf("err = %v, want errArchiveUnknown", err)
+	}
+}
+
+func TestBootExtractsArchive(t *testing.T) {
+	archive := writeZip(t, []entry{{name: "main.go", body: "package main\n"}})
+	rt := newFakeRuntime()
+
+	s, err := StartNewSession(context.Background(), rt, testConfig(t), "token", Options{Archive: archive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+
+	st := s.Status()
+	if st.State != StateReady || st.Since[StateExtracting].IsZero() {
+		t.Fatalf("status = %+v, want ready after extracting", st)
+	}
+	if p, _ := rt.proc(s.runtimeId); len(p.seen) != 1 || p.seen[0] != "main.go" {
+		t.Fatalf("editor saw %v, want the extracted files", p.seen)
+	}
+	if _, err := os.Stat(archive); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("uploaded archive was not removed")
+	}
+}
@@ -0,0 +1,178 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"nvimanywhere/internal/config"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxSparsePaths bounds the sparse-checkout list of a single request.
+const maxSparsePaths = 64
+
+// cloneSpec is the validated part of Options that shapes the clone.
+type cloneSpec struct {
+	target repoTarget
+	ref    string
+	// depth is the number of commits fetched, or -1 for all of them.
+	depth  int
+	sparse []string
+}
+
+// commitSHA matches full SHA-1 and SHA-256 object names. Servers only hand
+// out objects by full name, so abbreviated hashes are treated as ref names.
+var commitSHA = regexp.MustCompile(`^(?:[0-9a-fA-F]{40}|[0-9a-fA-F]{64})$`)
+
+// resolveClone validates the clone options of a request and fills in the
+// configured depth.
+func resolveClone(ctx context.Context, cfg *config.SessionRuntime, opts Options) (cloneSpec, error) {
+	spec := cloneSpec{ref: opts.Ref, depth: cfg.CloneDepth}
+
+	if opts.Repo == "" {
+		if opts.Ref != "" || opts.Depth != 0 || len(opts.Sparse) > 0 || opts.Credentials != nil {
+			return cloneSpec{}, fmt.Errorf("%w: ref, depth, sparse and credentials need a repo", SessionRequestIsInvalid)
+		}
+		return spec, nil
+	}
+	target, err := checkRepo(ctx, cfg.Repos, opts.Repo)
+	if err != nil {
+		return cloneSpec{}, fmt.Errorf("%w: %v", SessionRequestIsInvalid, err)
+	}
+	spec.target = target
+	if opts.Credentials != nil {
+		if err := validateCredentials(opts.Repo, opts.Credentials); err != nil {
+			return cloneSpec{}, fmt.Errorf("%w: %v", SessionRequestIsInvalid, err)
+		}
+	}
+	if opts.Ref != "" {
+		if err := validateRef(opts.Ref); err != nil {
+			return cloneSpec{}, fmt.Errorf("%w: %v", SessionRequestIsInvalid, err)
+		}
+	}
+	if opts.Depth < -1 {
+		return cloneSpec{}, fmt.Errorf("%w: depth must be > 0, or -1 for the full history", SessionRequestIsInvalid)
+	}
+	if opts.Depth != 0 {
+		spec.depth = opts.Depth
+	}
+	if len(opts.Sparse) > maxSparsePaths {
+		return cloneSpec{}, fmt.Errorf("%w: at most %d sparse paths are allowed", SessionRequestIsInvalid, maxSparsePaths)
+	}
+	for _, p := range opts.Sparse {
+		clean, err := cleanSparsePath(p)
+		if err != nil {
+			return cloneSpec{}, fmt.Errorf("%w: %v", SessionRequestIsInvalid, err)
+		}
+		spec.sparse = append(spec.sparse, clean)
+	}
+	return spec, nil
+}
+
+// validateRef applies the rules of git check-ref-format that matter here.
+// Refs end up as git arguments, so a leading '-' is rejected as well.
+func validateRef(ref string) error {
+	bad := func(why string) error { return fmt.Errorf("ref %q %s", ref, why) }
+
+	switch {
+	case len(ref) > 255:
+		return bad("is too long")
+	case strings.HasPrefix(ref, "-"):
+		return bad("must not start with '-'")
+	case strings.HasPrefix(ref, "/"), strings.HasSuffix(ref, "/"):
+		return bad("must not start or end with '/'")
+	case strings.HasSuffix(ref, "."), strings.HasSuffix(ref, ".lock"):
+		return bad("must not end with '.' or '.lock'")
+	case strings.Contains(ref, ".."), strings.Contains(ref, "//"), strings.Contains(ref, "@{"):
+		return bad("contains an invalid sequence")
+	case ref == "@":
+		return bad("is not a ref")
+	}
+	for _, r := range ref {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return bad("contains an invalid character")
+		}
+	}
+	for _, part := range strings.Split(ref, "/") {
+		if strings.HasPrefix(part, ".") {
+			return bad("has a component starting with '.'")
+		}
+	}
+	return nil
+}
+
+// cleanSparsePath turns a sparse-checkout entry into a clean directory
+// path inside the repository.
+func cleanSparsePath(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" || strings.HasPrefix(p, "/") || strings.HasPrefix(p, "-") || strings.ContainsAny(p, "\x00\n\r") {
+		return "", fmt.Errorf("sparse path %q is invalid", p)
+	}
+	clean := path.Clean(p)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("sparse path %q is outside the repository", p)
+	}
+	return clean, nil
+}
+
+// needsFetch reports whether the ref can't be cloned with --branch, which
+// only knows branches and tags: commits and other refs such as
+// refs/pull/123/head are fetched into an empty repository instead.
+func (c cloneSpec) needsFetch() bool {
+	if commitSHA.MatchString(c.ref) {
+		return true
+	}
+	return strings.HasPrefix(c.ref, "refs/") && c.branch() == c.ref
+}
+
+// branch is the ref as a name git clone --branch understands.
+func (c cloneSpec) branch() string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/"} {
+		if name, ok := strings.CutPrefix(c.ref, prefix); ok {
+			return name
+		}
+	}
+	return c.ref
+}
+
+// commands returns the git invocations that populate the workspace. They
+// run in order inside the workspace directory.
+func (c cloneSpec) commands(url string) [][]string {
+	// A full-history clone leaves out --depth, which would make it shallow.
+	var depth []string
+	if c.depth > 0 {
+		depth = []string{"--depth=" + strconv.Itoa(c.depth)}
+	}
+
+	if c.needsFetch() {
+		cmds := [][]string{
+			{"init", "-q"},
+			{"remote", "add", "origin", url},
+		}
+		if len(c.sparse) > 0 {
+			cmds = append(cmds, append([]string{"sparse-checkout", "set", "--"}, c.sparse...))
+		}
+		return append(cmds,
+			slices.Concat([]string{"fetch", "--progress"}, depth, []string{"--filter=blob:none", "--no-tags", "origin", c.ref}),
+			[]string{"checkout", "-q", "--detach", "FETCH_HEAD"},
+		)
+	}
+
+	clone := slices.Concat([]string{"clone", "--progress"}, depth, []string{"--filter=blob:none", "--single-branch", "--no-tags"})
+	if c.ref != "" {
+		clone = append(clone, "--branch", c.branch())
+	}
+	if len(c.sparse) > 0 {
+		// --sparse checks out only the top-level files; the set below
+		// then adds the requested directories.
+		clone = append(clone, "--sparse")
+	}
+	cmds := [][]string{append(clone, "--", url, ".")}
+	if len(c.sparse) > 0 {
+		cmds = append(cmds, append([]string{"sparse-checkout", "set", "--"}, c.sparse...))
+	}
+	return cmds
+}
@@ -0,0 +1,164 @@
+package sessions
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Credentials authenticate the clone of a private repository: a token for
+// HTTPS remotes or a deploy key for SSH remotes. They only reach git
+// through its environment and a temporary key file next to (not in) the
+// workspace, and only for the clone and for pushes.
+type Credentials struct {
+	// Username goes with Token; most forges accept any non-empty name.
+	Username string `json:"username"`
+	Token    string `json:"token"`
+	// SSHKey is an unencrypted private key in OpenSSH or PEM format.
+	SSHKey string `json:"ssh_key"`
+}
+
+const defaultTokenUsername = "x-access-token"
+
+// tokenHelper answers git's credential requests from the environment, so
+// the token never shows up in argv or in the workspace's git config.
+const tokenHelper = `!f() { test "$1" = get || exit 0; echo "username=$NVA_GIT_USERNAME"; echo "password=$NVA_GIT_TOKEN"; }; f`
+
+// gitAuth is how a set of credentials is handed to git.
+type gitAuth struct {
+	// config goes before the git subcommand as -c options.
+	config []string
+	env    []string
+	// ssh are options for the ssh command git runs, quoted for sh; see
+	// repoTarget.gitEnv.
+	ssh []string
+	// mounts are host files git reads, see ExecCommand.Mounts.
+	mounts []string
+	// secrets are redacted from anything git prints.
+	secrets []string
+	cleanup func()
+}
+
+// isSSHRemote reports whether git will reach repo over SSH, either as an
+// ssh:// URL or in the scp-like user@host:path form.
+func isSSHRemote(repo string) bool {
+	if u, err := url.Parse(repo); err == nil && u.Scheme != "" && strings.Contains(repo, "://") {
+		return u.Scheme == "ssh" || u.Scheme == "git+ssh"
+	}
+	at, colon := strings.Index(repo, "@"), strings.Index(repo, ":")
+	return colon > 0 && (at < 0 || at < colon) && !strings.Contains(repo[:colon], "/")
+}
+
+// validateCredentials checks that the credentials fit the remote.
+func validateCredentials(repo string, c *Credentials) error {
+	if c.Token == "" && c.SSHKey == "" {
+		return fmt.Errorf("credentials need a token or an ssh_key")
+	}
+	if c.Token != "" && c.SSHKey != "" {
+		return fmt.Errorf("credentials take a token or an ssh_key, not both")
+	}
+	if strings.ContainsAny(c.Username+c.Token, "\n\r\x00") {
+		return fmt.Errorf("credentials contain invalid characters")
+	}
+	if c.Token != "" {
+		// Anything but TLS would send the token in the clear.
+		if u, err := url.Parse(repo); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("a token needs an https:// repo URL")
+		}
+		if u, _ := url.Parse(repo); u.User != nil {
+			return fmt.Errorf("a token can't be combined with credentials in the repo URL")
+		}
+	}
+	if c.SSHKey != "" && !isSSHRemote(repo) {
+		return fmt.Errorf("an ssh_key needs an ssh repo URL")
+	}
+	return nil
+}
+
+// gitAuth prepares git to authenticate with c against repo. A deploy key
+// is written to keyDir, which must be visible to the runtime like the
+// workspaces are.
+func (c *Credentials) gitAuth(repo, knownHosts, keyDir string) (gitAuth, error) {
+	if c.Token != "" {
+		u, err := url.Parse(repo)
+		if err != nil {
+			return gitAuth{}, fmt.Errorf("Failed to parse repo url: %w", err)
+		}
+		username := c.Username
+		if username == "" {
+			username = defaultTokenUsername
+		}
+		// The helper is scoped to the repo's origin, so a redirect to
+		// another host never gets the token.
+		origin := u.Scheme + "://" + u.Host
+		return gitAuth{
+			config: []string{
+				"-c", "credential.helper=",
+				"-c", "credential." + origin + ".helper=" + tokenHelper,
+			},
+			env:     []string{"NVA_GIT_USERNAME=" + username, "NVA_GIT_TOKEN=" + c.Token},
+			secrets: []string{c.Token},
+			cleanup: func() {},
+		}, nil
+	}
+
+	keyDir, err := filepath.Abs(keyDir)
+	if err != nil {
+		return gitAuth{}, fmt.Errorf("Failed to resolve key dir: %w", err)
+	}
+	f, err := os.CreateTemp(keyDir, ".deploy-key-*")
+	if err != nil {
+		return gitAuth{}, fmt.Errorf("Failed to create key file: %w", err)
+	}
+	key := c.SSHKey
+	if !strings.HasSuffix(key, "\n") {
+		// ssh refuses keys without the trailing newline.
+		key += "\n"
+	}
+	_, err = f.WriteString(key)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	if err != nil {
+		cleanup()
+		return gitAuth{}, fmt.Errorf("Failed to write key file: %w", err)
+	}
+
+	ssh := []string{
+		"-i", shellQuote(f.Name()),
+		"-o", "IdentitiesOnly=yes",
+		"-o", "IdentityAgent=none",
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=yes",
+	}
+	mounts := []string{f.Name()}
+	if knownHosts != "" {
+		if knownHosts, err = filepath.Abs(knownHosts); err != nil {
+			cleanup()
+			return gitAuth{}, fmt.Errorf("Failed to resolve known hosts: %w", err)
+		}
+		ssh = append(ssh, "-o", "UserKnownHostsFile="+shellQuote(knownHosts))
+		mounts = append(mounts, knownHosts)
+	}
+	return gitAuth{
+		ssh:     ssh,
+		mounts:  mounts,
+		cleanup: cleanup,
+	}, nil
+}
+
+// redact hides secrets in text that may end up in errors and logs.
+func (a gitAuth) redact(text string) string {
+	for _, secret := range a.secrets {
+		text = strings.ReplaceAll(text, secret, "***")
+	}
+	return text
+}
+
+// shellQuote quotes s for GIT_SSH_COMMAND, which git runs through sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,374 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"nvimanywhere/internal/config"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+type dockerRuntime struct {
+	gatewayID  string
+	imageName  string
+	execImage  string
+	configPath string
+	// execUser is the uid:gid Exec runs as: the container-side owner of
+	// the workspaces, deploy keys and push dirs the gateway creates.
+	execUser string
+	cli      *client.Client
+	security *securityProfile
+	networks *sessionNetworks
+
+	// specHook lets engines speaking the Docker API (Podman) adjust the
+	// container spec right before it is created.
+	specHook func(*container.Config, *container.HostConfig)
+}
+
+func newDockerRuntime(cfg *config.SessionRuntime) (*dockerRuntime, error) {
+	return newDockerAPIRuntime(cfg, client.FromEnv)
+}
+
+func newDockerAPIRuntime(cfg *config.SessionRuntime, opts ...client.Opt) (*dockerRuntime, error) {
+	cli, err := client.NewClientWithOpts(
+		append(opts, client.WithAPIVersionNegotiation())...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	security, err := loadSecurityProfile(cfg.Security)
+	if err != nil {
+		return nil, err
+	}
+	return &dockerRuntime{
+		gatewayID:  cfg.GatewayID,
+		imageName:  cfg.ImageName,
+		execImage:  cfg.ExecImage,
+		configPath: cfg.NvimConfigPath,
+		execUser:   fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		cli:        cli,
+		security:   security,
+		networks:   &sessionNetworks{cfg: cfg.Network, cli: cli},
+	}, nil
+}
+
+func (runner *dockerRuntime) buildContainerSpec(opts StartOptions) (*container.Config, *container.HostConfig) {
+	env := []string{
+		"TERM=xterm-256color",
+		"COLORTERM=truecolor",
+		"NVIM_LOG_FILE=/workspace/tmp/nvim.log",
+		"NVIM_LOG_LEVEL=debug",
+	}
+	if runner.imageName == "" {
+		runner.imageName = "ghcr.io/neovim/neovim:v0.10.3"
+	}
+
+	cfg := &container.Config{
+		Image:        runner.imageName,
+		Tty:          true,
+		OpenStdin:    true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          env,
+		WorkingDir:   "/workspace",
+		Labels:       containerLabels(runner.gatewayID, opts.Labels),
+	}
+
+	mounts := []mount.Mount{
+		{
+			Type:     mount.TypeBind,
+			Source:   opts.Workspace,
+			Target:   "/workspace",
+			ReadOnly: false,
+		},
+	}
+	if runner.configPath != "" {
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   runner.configPath,
+			Target:   "/home/nvim/.config/nvim",
+			ReadOnly: false,
+		})
+	}
+
+	hostCfg := &container.HostConfig{
+		Mounts:    mounts,
+		LogConfig: container.LogConfig{Type: "none"},
+		Resources: containerResources(opts.Resources),
+	}
+	runner.security.apply(hostCfg)
+	return cfg, hostCfg
+}
+
+// containerLabels copies the session's labels, so Exec can set its role
+// without touching opts. Every container carries the gateway's id, which
+// is what List looks for.
+func containerLabels(gatewayID string, labels map[string]string) map[string]string {
+	out := make(map[string]string, len(labels)+2)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[LabelGateway] = gatewayID
+	out[LabelRole] = roleEditor
+	return out
+}
+
+// containerResources maps session limits onto the container's cgroup and
+// rlimit settings. Memory is never swapped unless memory_swap says so.
+func containerResources(res config.Resources) container.Resources {
+	out := container.Resources{
+		NanoCPUs:    int64(res.CPUs * 1e9),
+		BlkioWeight: res.BlkioWeight,
+	}
+	out.Memory, _ = res.MemoryBytes()
+	out.MemorySwap, _ = res.MemorySwapBytes()
+	if out.MemorySwap == 0 {
+		out.MemorySwap = out.Memory
+	}
+	if res.PidsLimit > 0 {
+		pids := res.PidsLimit
+		out.PidsLimit = &pids
+	}
+	names := make([]string, 0, len(res.Ulimits))
+	for name := range res.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := res.Ulimits[name]
+		out.Ulimits = append(out.Ulimits, &container.Ulimit{Name: name, Soft: v, Hard: v})
+	}
+	return out
+}
+
+func (runner *dockerRuntime) Start(ctx context.Context, opts StartOptions) (string, error) {
+	cfg, hostCfg := runner.buildContainerSpec(opts)
+	if err := runner.networks.apply(ctx, opts.Network, cfg, hostCfg); err != nil {
+		return "", err
+	}
+	if runner.specHook != nil {
+		runner.specHook(cfg, hostCfg)
+	}
+
+	resp, err := runner.cli.ContainerCreate(ctx, cfg, hostCfg, nil, nil, "")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create container: %v", err)
+	}
+
+	if err := runner.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return "", err
+	}
+
+	return resp.ID, nil
+}
+
+func (runner *dockerRuntime) Attach(
+	ctx context.Context, id string) (
+	io.Reader,
+	io.Writer,
+	func() error,
+	error) {
+	if id == "" {
+		return nil, nil, nil, containerNotStarted
+	}
+
+	att, err := runner.cli.ContainerAttach(ctx, id, container.AttachOptions{
+		Stream: true, Stdin: true, Stdout: true, Stderr: true, Logs: false,
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Attach container: %v", err)
+	}
+	closeAttach := att.Conn.Close
+
+	r := att.Reader
+	w := att.Conn
+
+	return r, w, closeAttach, nil
+}
+
+func (r *dockerRuntime) Terminate(ctx context.Context, id string) error {
+	if id == "" {
+		return containerNotStarted
+	}
+
+	if err := r.cli.ContainerStop(ctx, id, container.StopOptions{}); err != nil {
+		return err
+	}
+
+	statusCh, errCh := r.cli.ContainerWait(
+		ctx,
+		id,
+		container.WaitConditionNotRunning,
+	)
+
+	select {
+	case <-statusCh:
+	case err := <-errCh:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if err := r.cli.ContainerRemove(ctx, id, container.RemoveOptions{}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (runner *dockerRuntime) Resize(ctx context.Context, id string, cols, rows int) error {
+	if id == "" {
+		return containerNotStarted
+	}
+	return runner.cli.ContainerResize(ctx, id, container.ResizeOptions{Width: uint(cols), Height: uint(rows)})
+}
+
+func (runner *dockerRuntime) Inspect(ctx context.Context, id string) (RuntimeInfo, error) {
+	if id == "" {
+		return RuntimeInfo{}, containerNotStarted
+	}
+	resp, err := runner.cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return RuntimeInfo{}, fmt.Errorf("Inspect container: %v", err)
+	}
+
+	info := RuntimeInfo{ID: resp.ID}
+	if resp.Config != nil {
+		info.Labels = resp.Config.Labels
+	}
+	if resp.State != nil {
+		info.Running = resp.State.Running
+		info.ExitCode = resp.State.ExitCode
+		info.StartedAt, _ = time.Parse(time.RFC3339Nano, resp.State.StartedAt)
+	}
+	return info, nil
+}
+
+// List returns the containers labelled with the gateway's id, whatever
+// their state.
+func (runner *dockerRuntime) List(ctx context.Context) ([]RuntimeInfo, error) {
+	containers, err := runner.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelGateway+"="+runner.gatewayID)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("List containers: %v", err)
+	}
+	infos := make([]RuntimeInfo, 0, len(containers))
+	for _, c := range containers {
+		infos = append(infos, RuntimeInfo{
+			ID:        c.ID,
+			Running:   c.State == container.StateRunning,
+			StartedAt: time.Unix(c.Created, 0),
+			Labels:    c.Labels,
+		})
+	}
+	return infos, nil
+}
+
+// Exec runs cmd in a throwaway container built from the session's spec, so
+// it gets the same mounts, limits, security profile and network as the
+// editor, unless cmd asks for another network. It runs as execUser rather
+// than the image's user, so it can use the files the gateway wrote.
+func (runner *dockerRuntime) Exec(ctx context.Context, opts StartOptions, cmd ExecCommand) error {
+	if len(cmd.Args) == 0 {
+		return errors.New("Exec needs a command")
+	}
+	cfg, hostCfg := runner.buildContainerSpec(opts)
+	if runner.execImage != "" {
+		cfg.Image = runner.execImage
+	}
+	cfg.Tty = false
+	cfg.OpenStdin = false
+	cfg.AttachStdin = false
+	cfg.Labels[LabelRole] = roleExec
+	cfg.User = runner.execUser
+	cfg.Entrypoint = cmd.Args[:1]
+	cfg.Cmd = cmd.Args[1:]
+	cfg.Env = append(cfg.Env, cmd.Env...)
+	for _, path := range cmd.Mounts {
+		hostCfg.Mounts = append(hostCfg.Mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   path,
+			Target:   path,
+			ReadOnly: true,
+		})
+	}
+
+	network := opts.Network
+	if cmd.Network != "" {
+		network = cmd.Network
+	}
+	if err := runner.networks.apply(ctx, network, cfg, hostCfg); err != nil {
+		return err
+	}
+	if runner.specHook != nil {
+		runner.specHook(cfg, hostCfg)
+	}
+
+	resp, err := runner.cli.ContainerCreate(ctx, cfg, hostCfg, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("Failed to create container: %v", err)
+	}
+	defer func() {
+		// Force also kills the command when ctx was cancelled mid-run.
+		rmCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		runner.cli.ContainerRemove(rmCtx, resp.ID, container.RemoveOptions{Force: true})
+	}()
+
+	att, err := runner.cli.ContainerAttach(ctx, resp.ID, container.AttachOptions{
+		Stream: true, Stdout: true, Stderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("Attach container: %v", err)
+	}
+	defer att.Close()
+
+	// Waiting on the next exit before starting can't miss a quick exit.
+	statusCh, errCh := runner.cli.ContainerWait(ctx, resp.ID, container.WaitConditionNextExit)
+	if err := runner.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return err
+	}
+
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	copied := make(chan struct{})
+	go func() {
+		stdcopy.StdCopy(stdout, stderr, att.Reader)
+		close(copied)
+	}()
+
+	select {
+	case status := <-statusCh:
+		<-copied
+		if status.Error != nil {
+			return errors.New(status.Error.Message)
+		}
+		if status.StatusCode != 0 {
+			return fmt.Errorf("exit status %d", status.StatusCode)
+		}
+		return nil
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
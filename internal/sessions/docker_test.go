@@ -0,0 +1,79 @@
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"nvimanywhere/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestExecUsesGatewayFiles runs against a real engine and image, set with
+// NVA_TEST_IMAGE (e.g. the image built from docker/session), since the
+// fake runtime runs everything as the gateway user. The image's own user
+// can't read a 0600 deploy key or write a directory the gateway created.
+func TestExecUsesGatewayFiles(t *testing.T) {
+	image := os.Getenv("NVA_TEST_IMAGE")
+	if image == "" {
+		t.Skip("NVA_TEST_IMAGE is not set")
+	}
+	cfg := testConfig(t)
+	cfg.Runtime = config.RuntimeDocker
+	cfg.ImageName = image
+	cfg.Network.Mode = config.NetworkNone
+	runner, err := newDockerRuntime(cfg)
+	if err != nil {
+		t.Skipf("docker is unavailable: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := runner.cli.Ping(ctx); err != nil {
+		t.Skipf("docker is unavailable: %v", err)
+	}
+
+	workspace := filepath.Join(cfg.BasePath, "token")
+	if err := prepareWorkspaceDir(workspace); err != nil {
+		t.Fatal(err)
+	}
+	creds := &Credentials{SSHKey: "not a key"}
+	auth, err := creds.gitAuth("git@example.com:org/repo.git", "", cfg.BasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auth.cleanup()
+	opts := StartOptions{Workspace: workspace, Network: config.NetworkNone}
+
+	out := &bytes.Buffer{}
+	err = runner.Exec(ctx, opts, ExecCommand{
+		Args:   []string{"sh", "-c", `cat "$1" >/dev/null && git init -q && touch notes.txt`, "sh", auth.mounts[0]},
+		Mounts: auth.mounts,
+		Stdout: out,
+		Stderr: out,
+	})
+	if err != nil {
+		t.Fatalf("exec in workspace: %v, %s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "notes.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	scratch, err := os.MkdirTemp(cfg.BasePath, ".push-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(scratch, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	opts.Workspace = scratch
+	out.Reset()
+	err = runner.Exec(ctx, opts, ExecCommand{
+		Args:   []string{"sh", "-c", pushScratch, "sh", workspace},
+		Mounts: []string{workspace},
+		Stderr: out,
+	})
+	if err != nil {
+		t.Fatalf("exec in push dir: %v, %s", err, out)
+	}
+}
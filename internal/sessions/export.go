@@ -0,0 +1,302 @@
+package sessions
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Export formats.
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+
+	// PatchDiff is every change since the session started, committed or
+	// not, as one binary diff for git apply.
+	PatchDiff = "diff"
+	// PatchMbox is the commits made in the session, for git am.
+	PatchMbox = "mbox"
+)
+
+// recordBaseCommit remembers where the workspace started, if it is a repo.
+func (s *Session) recordBaseCommit() {
+	if s.repoUrl == "" && s.archive == "" && !s.persistent {
+		return
+	}
+	out := &bytes.Buffer{}
+	err := s.runtime.Exec(s.ctx, s.opts, ExecCommand{
+		Args:   []string{"git", "rev-parse", "--verify", "-q", "HEAD"},
+		Stdout: out,
+	})
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.baseCommit = strings.TrimSpace(out.String())
+	s.mu.Unlock()
+}
+
+// exportable reports whether the workspace is complete and still there.
+func (s *Session) exportable() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.baseCommit, stateErr(s.state)
+}
+
+// WriteArchive streams the workspace to w. With gitignore set, only files
+// git would track are included: tracked files plus untracked ones that no
+// .gitignore excludes, which needs the workspace to be a repo.
+func (s *Session) WriteArchive(ctx context.Context, w io.Writer, format string, gitignore bool) error {
+	base, err := s.exportable()
+	if err != nil {
+		return err
+	}
+	if format != ArchiveTarGz && format != ArchiveZip {
+		return fmt.Errorf("%w: unknown archive format %q", SessionRequestIsInvalid, format)
+	}
+
+	var files []string
+	if gitignore {
+		if base == "" {
+			return SessionIsNotARepo
+		}
+		if files, err = s.gitFiles(ctx); err != nil {
+			return err
+		}
+	} else if files, err = walkWorkspace(s.rootPath); err != nil {
+		return err
+	}
+
+	// Paths come from a workspace the user controls, so every file is read
+	// through a Root: a symlink or ".." can never lead out of it.
+	root, err := os.OpenRoot(s.rootPath)
+	if err != nil {
+		return fmt.Errorf("Failed to open workspace: %w", err)
+	}
+	defer root.Close()
+	if format == ArchiveZip {
+		return packZip(w, root, files)
+	}
+	return packTarGz(w, root, files)
+}
+
+// gitFiles lists the workspace the way git sees it, honouring .gitignore.
+func (s *Session) gitFiles(ctx context.Context) ([]string, error) {
+	out, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	err := s.runtime.Exec(ctx, s.opts, ExecCommand{
+		Args:   []string{"git", "ls-files", "-z", "--cached", "--others", "--exclude-standard"},
+		Stdout: out,
+		Stderr: stderr,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list files: %v, %s", err, stderr.String())
+	}
+	var files []string
+	for _, name := range strings.Split(out.String(), "\x00") {
+		// The index is the user's; entries such as "../x" are not files.
+		if name != "" && filepath.IsLocal(filepath.FromSlash(name)) {
+			files = append(files, filepath.FromSlash(name))
+		}
+	}
+	return files, nil
+}
+
+// walkWorkspace lists every file, directory and symlink below root.
+func walkWorkspace(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	return files, err
+}
+
+// lstatEntry returns what rel is in root, or false for entries to skip:
+// ones deleted since they were listed, as the editor is still running,
+// and ones that lead out of the workspace.
+func lstatEntry(root *os.Root, rel string) (fs.FileInfo, bool) {
+	if !filepath.IsLocal(rel) {
+		return nil, false
+	}
+	info, err := root.Lstat(rel)
+	return info, err == nil
+}
+
+func packTarGz(w io.Writer, root *os.Root, files []string) error {
+	bw := bufio.NewWriterSize(w, 64*1024)
+	gz := gzip.NewWriter(bw)
+	tw := tar.NewWriter(gz)
+
+	for _, rel := range files {
+		info, ok := lstatEntry(root, rel)
+		if !ok {
+			continue
+		}
+		var err error
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = root.Readlink(rel); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			continue
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		// Owner names of the gateway host mean nothing to the user.
+		hdr.Uname, hdr.Gname = "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			if err := copyFile(tw, root, rel, hdr.Size); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func packZip(w io.Writer, root *os.Root, files []string) error {
+	zw := zip.NewWriter(w)
+
+	for _, rel := range files {
+		info, ok := lstatEntry(root, rel)
+		if !ok {
+			continue
+		}
+		isLink := info.Mode()&fs.ModeSymlink != 0
+		if !isLink && !info.Mode().IsRegular() && !info.IsDir() {
+			continue
+		}
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		} else {
+			hdr.Method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		switch {
+		case isLink:
+			// zip stores the link target as the entry's content.
+			target, err := root.Readlink(rel)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(fw, target); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if err := copyFile(fw, root, rel, info.Size()); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// copyFile copies exactly size bytes, padding or truncating a file that
+// changed after it was stat'ed so the archive stays well-formed. A file
+// swapped for something else since is written as zeros.
+func copyFile(w io.Writer, root *os.Root, rel string, size int64) error {
+	var r io.Reader = zeroReader{}
+	if f, err := root.Open(rel); err == nil {
+		defer f.Close()
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			r = f
+		}
+	}
+	n, err := io.Copy(w, io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	if n < size {
+		_, err = io.CopyN(w, zeroReader{}, size-n)
+	}
+	return err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// patchScript diffs the work tree, untracked files included, against the
+// base commit. It stages into a throwaway index so the user's own index
+// is left alone.
+const patchScript = `set -e
+idx=$(mktemp)
+trap 'rm -f "$idx"' EXIT
+export GIT_INDEX_FILE="$idx"
+git read-tree HEAD
+git add -A
+git diff --cached --binary "$1"`
+
+// WritePatch streams the session's changes in the given format to w.
+func (s *Session) WritePatch(ctx context.Context, w io.Writer, format string) error {
+	base, err := s.exportable()
+	if err != nil {
+		return err
+	}
+	if base == "" {
+		return SessionIsNotARepo
+	}
+
+	var args []string
+	switch format {
+	case PatchDiff:
+		args = []string{"sh", "-c", patchScript, "sh", base}
+	case PatchMbox:
+		args = []string{"git", "format-patch", "--stdout", "--binary", base + "..HEAD"}
+	default:
+		return fmt.Errorf("%w: unknown patch format %q", SessionRequestIsInvalid, format)
+	}
+
+	stderr := &bytes.Buffer{}
+	err = s.runtime.Exec(ctx, s.opts, ExecCommand{Args: args, Stdout: w, Stderr: stderr})
+	if err != nil {
+		return fmt.Errorf("Failed to export patch: %v, %s", err, stderr.String())
+	}
+	return nil
+}
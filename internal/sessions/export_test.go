@@ -0,0 +1,203 @@
+package sessions
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// editedSession boots a clone of testRepo and changes it the way a user
+// would: one commit, one uncommitted edit, an untracked and an ignored file.
+func editedSession(t *testing.T) *Session {
+	t.Helper()
+	s, err := StartNewSession(context.Background(), newFakeRuntime(), testConfig(t), "token", Options{Repo: testRepo(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+	if st := s.State(); st != StateReady {
+		t.Fatalf("state = %s, want ready", st)
+	}
+
+	root := s.rootPath
+	writeFile(t, filepath.Join(root, ".gitignore"), "build/\n")
+	writeFile(t, filepath.Join(root, "committed.go"), "package main\n")
+	runGit(t, root, "add", ".")
+	runGit(t, root, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "work")
+	writeFile(t, filepath.Join(root, "main.go"), "package main\n\nfunc main() {}\n")
+	writeFile(t, filepath.Join(root, "untracked.go"), "package main\n")
+	writeFile(t, filepath.Join(root, "build", "out.bin"), "binary")
+	if err := os.Symlink("main.go", filepath.Join(root, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func tarNames(t *testing.T, data []byte) map[string]*tar.Header {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	names := make(map[string]*tar.Header)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[hdr.Name] = hdr
+	}
+}
+
+func TestWriteArchiveTarGz(t *testing.T) {
+	s := editedSession(t)
+
+	var buf bytes.Buffer
+	if err := s.WriteArchive(context.Background(), &buf, ArchiveTarGz, false); err != nil {
+		t.Fatal(err)
+	}
+	names := tarNames(t, buf.Bytes())
+	for _, want := range []string{"main.go", "src/", "src/lib.go", "untracked.go", "build/out.bin", ".git/HEAD"} {
+		if names[want] == nil {
+			t.Errorf("archive is missing %s", want)
+		}
+	}
+	if link := names["link.go"]; link == nil || link.Typeflag != tar.TypeSymlink || link.Linkname != "main.go" {
+		t.Errorf("link.go = %+v, want a symlink to main.go", link)
+	}
+}
+
+func TestWriteArchiveGitignore(t *testing.T) {
+	s := editedSession(t)
+
+	var buf bytes.Buffer
+	if err := s.WriteArchive(context.Background(), &buf, ArchiveZip, true); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	slices.Sort(names)
+	want := []string{".gitignore", "committed.go", "docs/README.md", "link.go", "main.go", "src/lib.go", "untracked.go"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("archive has %v, want %v", names, want)
+	}
+}
+
+func TestWriteArchiveStaysInWorkspace(t *testing.T) {
+	s := editedSession(t)
+	outside := t.TempDir()
+	writeFile(t, filepath.Join(outside, "secret.txt"), "host secret\n")
+
+	// The index is the user's: it can list a path through a symlink that
+	// leads out of the workspace, which git itself would never add.
+	root := s.rootPath
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	blob := strings.TrimSpace(runGit(t, root, "hash-object", "-w", "main.go"))
+	runGit(t, root, "update-index", "--add", "--cacheinfo", "100644,"+blob+",escape/secret.txt")
+
+	var buf bytes.Buffer
+	if err := s.WriteArchive(context.Background(), &buf, ArchiveTarGz, true); err != nil {
+		t.Fatal(err)
+	}
+	names := tarNames(t, buf.Bytes())
+	if names["escape/secret.txt"] != nil {
+		t.Fatal("archive has a file from outside the workspace")
+	}
+	if link := names["escape"]; link == nil || link.Typeflag != tar.TypeSymlink {
+		t.Fatalf("escape = %+v, want it kept as a symlink", link)
+	}
+}
+
+func TestWriteArchiveGitignoreNeedsRepo(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	err := s.WriteArchive(context.Background(), io.Discard, ArchiveTarGz, true)
+	if !errors.Is(err, SessionIsNotARepo) {
+		t.Fatalf("err = %v, want SessionIsNotARepo", err)
+	}
+}
+
+func TestWritePatchDiff(t *testing.T) {
+	s := editedSession(t)
+
+	var buf bytes.Buffer
+	if err := s.WritePatch(context.Background(), &buf, PatchDiff); err != nil {
+		t.Fatal(err)
+	}
+	patch := buf.String()
+	for _, want := range []string{"b/committed.go", "b/main.go", "+func main() {}", "b/untracked.go", "b/link.go"} {
+		if !strings.Contains(patch, want) {
+			t.Errorf("patch is missing %q:\n%s", want, patch)
+		}
+	}
+	if strings.Contains(patch, "out.bin") {
+		t.Error("patch includes an ignored file")
+	}
+	// The user's own index is left untouched.
+	if status := runGit(t, s.rootPath, "status", "--porcelain"); !strings.Contains(status, "?? untracked.go") {
+		t.Errorf("status = %q, want untracked.go still untracked", status)
+	}
+
+	// The diff applies cleanly on a fresh clone.
+	fresh := t.TempDir()
+	runGit(t, fresh, "clone", "-q", s.repoUrl, ".")
+	cmd := exec.Command("git", "apply", "--check", "-")
+	cmd.Dir = fresh
+	cmd.Stdin = strings.NewReader(patch)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git apply: %v\n%s", err, out)
+	}
+}
+
+func TestWritePatchMbox(t *testing.T) {
+	s := editedSession(t)
+
+	var buf bytes.Buffer
+	if err := s.WritePatch(context.Background(), &buf, PatchMbox); err != nil {
+		t.Fatal(err)
+	}
+	patch := buf.String()
+	if !strings.Contains(patch, "Subject: [PATCH] work") || !strings.Contains(patch, "b/committed.go") {
+		t.Errorf("mbox is missing the session's commit:\n%s", patch)
+	}
+	if strings.Contains(patch, "untracked.go") {
+		t.Error("mbox includes uncommitted changes")
+	}
+}
+
+func TestWritePatchRejects(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	if err := s.WritePatch(context.Background(), io.Discard, PatchDiff); !errors.Is(err, SessionIsNotARepo) {
+		t.Errorf("plain workspace: err = %v, want SessionIsNotARepo", err)
+	}
+
+	s = editedSession(t)
+	if err := s.WritePatch(context.Background(), io.Discard, "tarball"); !errors.Is(err, SessionRequestIsInvalid) {
+		t.Errorf("bad format: err = %v, want SessionRequestIsInvalid", err)
+	}
+	s.Close()
+	if err := s.WritePatch(context.Background(), io.Discard, PatchDiff); !errors.Is(err, SessionIsClosed) {
+		t.Errorf("closed: err = %v, want SessionIsClosed", err)
+	}
+}
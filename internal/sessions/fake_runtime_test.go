@@ -0,0 +1,205 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// fakeRuntime is an in-process Runtime. Every attachment is a loopback
+// pipe: whatever is written to the PTY input comes back as PTY output,
+// which is enough to drive Session without a container daemon.
+type fakeRuntime struct {
+	mu       sync.Mutex
+	nextID   int
+	procs    map[string]*fakeProc
+	startErr error
+	// execs records every Exec; commands run on the host like the local
+	// runtime, which is enough to clone and run hooks in tests.
+	execs []ExecCommand
+}
+
+type fakeProc struct {
+	mu         sync.Mutex
+	workspace  string
+	opts       StartOptions
+	labels     map[string]string
+	seen       []string
+	startedAt  time.Time
+	sizes      [][2]int
+	out        *io.PipeWriter
+	attaches   int
+	terminated bool
+}
+
+func newFakeRuntime() *fakeRuntime {
+	return &fakeRuntime{procs: make(map[string]*fakeProc)}
+}
+
+func (f *fakeRuntime) Start(ctx context.Context, opts StartOptions) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.startErr != nil {
+		return "", f.startErr
+	}
+	f.nextID++
+	id := fmt.Sprintf("fake-%d", f.nextID)
+	p := &fakeProc{workspace: opts.Workspace, opts: opts, startedAt: time.Now()}
+	p.labels = map[string]string{LabelRole: roleEditor}
+	for k, v := range opts.Labels {
+		p.labels[k] = v
+	}
+	// Remember what the editor would have seen on startup.
+	if entries, err := os.ReadDir(opts.Workspace); err == nil {
+		for _, e := range entries {
+			p.seen = append(p.seen, e.Name())
+		}
+	}
+	f.procs[id] = p
+	return id, nil
+}
+
+func (f *fakeRuntime) Attach(ctx context.Context, id string) (io.Reader, io.Writer, func() error, error) {
+	p, err := f.proc(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.terminated {
+		return nil, nil, nil, errors.New("fake: process is terminated")
+	}
+
+	pr, pw := io.Pipe()
+	p.out = pw
+	p.attaches++
+	closeAttach := func() error {
+		pw.Close()
+		return pr.Close()
+	}
+	return pr, pw, closeAttach, nil
+}
+
+func (f *fakeRuntime) Resize(ctx context.Context, id string, cols, rows int) error {
+	p, err := f.proc(id)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sizes = append(p.sizes, [2]int{cols, rows})
+	return nil
+}
+
+func (f *fakeRuntime) Terminate(ctx context.Context, id string) error {
+	p, err := f.proc(id)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.terminated = true
+	if p.out != nil {
+		p.out.Close()
+	}
+	return nil
+}
+
+func (f *fakeRuntime) Inspect(ctx context.Context, id string) (RuntimeInfo, error) {
+	p, err := f.proc(id)
+	if err != nil {
+		return RuntimeInfo{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return RuntimeInfo{ID: id, Running: !p.terminated, StartedAt: p.startedAt}, nil
+}
+
+func (f *fakeRuntime) Exec(ctx context.Context, opts StartOptions, cmd ExecCommand) error {
+	f.mu.Lock()
+	f.execs = append(f.execs, cmd)
+	f.mu.Unlock()
+
+	c := exec.CommandContext(ctx, cmd.Args[0], cmd.Args[1:]...)
+	c.Dir = opts.Workspace
+	c.Env = append(os.Environ(), cmd.Env...)
+	c.Stdout = cmd.Stdout
+	c.Stderr = cmd.Stderr
+	return c.Run()
+}
+
+func (f *fakeRuntime) List(ctx context.Context) ([]RuntimeInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var infos []RuntimeInfo
+	for id, p := range f.procs {
+		p.mu.Lock()
+		infos = append(infos, RuntimeInfo{ID: id, Running: !p.terminated, StartedAt: p.startedAt, Labels: p.labels})
+		p.mu.Unlock()
+	}
+	return infos, nil
+}
+
+// leave adds a process as if an earlier gateway had left it behind.
+func (f *fakeRuntime) leave(labels map[string]string, running bool) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	id := fmt.Sprintf("fake-%d", f.nextID)
+	f.procs[id] = &fakeProc{labels: labels, startedAt: time.Now(), terminated: !running}
+	return id
+}
+
+func (f *fakeRuntime) executed() []ExecCommand {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]ExecCommand(nil), f.execs...)
+}
+
+func (f *fakeRuntime) proc(id string) (*fakeProc, error) {
+	if id == "" {
+		return nil, containerNotStarted
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.procs[id]
+	if !ok {
+		return nil, fmt.Errorf("fake: no such process %q", id)
+	}
+	return p, nil
+}
+
+// emit writes b to the current attachment as if the editor printed it.
+func (f *fakeRuntime) started() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.procs)
+}
+
+func (p *fakeProc) emit(b []byte) error {
+	p.mu.Lock()
+	out := p.out
+	p.mu.Unlock()
+	if out == nil {
+		return errors.New("fake: not attached")
+	}
+	_, err := out.Write(b)
+	return err
+}
+
+func (p *fakeProc) resizes() [][2]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][2]int(nil), p.sizes...)
+}
+
+func (p *fakeProc) isTerminated() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.terminated
+}
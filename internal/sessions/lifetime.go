@@ -0,0 +1,91 @@
+package sessions
+
+import (
+	"encoding/json"
+	"nvimanywhere/internal/config"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Reasons a session expires, as reported by Expiry.
+const (
+	ExpiryIdle       = "idle"
+	ExpiryUnattached = "never attached"
+	ExpiryLifetime   = "maximum lifetime reached"
+)
+
+// touch records terminal activity in either direction.
+func (s *Session) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// IdleFor returns how long the terminal has seen no input or output.
+func (s *Session) IdleFor() time.Duration {
+	return time.Since(time.Unix(0, s.lastActive.Load()))
+}
+
+// Expiry returns when the session is due to be closed under limits and
+// why, or the zero time when it is not subject to them: failed sessions
+// are closed by their owner, closing ones are on their way out, and
+// disabled limits never expire.
+func (s *Session) Expiry(limits *config.Reaper) (time.Time, string) {
+	s.mu.Lock()
+	state := s.state
+	// A pooled session is given a new createdAt when it is handed out.
+	created := s.createdAt
+	ready := s.stateSince[StateReady]
+	attached := !s.stateSince[StateAttached].IsZero()
+	s.mu.Unlock()
+
+	switch state {
+	case StateFailed, StateClosing, StateClosed:
+		return time.Time{}, ""
+	}
+
+	var (
+		deadline time.Time
+		reason   string
+	)
+	earlier := func(since time.Time, limit time.Duration, why string) {
+		if limit < 0 {
+			return
+		}
+		if t := since.Add(limit); deadline.IsZero() || t.Before(deadline) {
+			deadline, reason = t, why
+		}
+	}
+	earlier(created, limits.MaxLifetime, ExpiryLifetime)
+	switch {
+	case ready.IsZero():
+		// Still cloning or starting; only the lifetime bounds that.
+	case !attached:
+		earlier(ready, limits.UnattachedTimeout, ExpiryUnattached)
+	default:
+		earlier(time.Unix(0, s.lastActive.Load()), limits.IdleTimeout, ExpiryIdle)
+	}
+	return deadline, reason
+}
+
+// Notify sends a control message such as {"type":"warning","reason":"..."}
+// to the attached client and reports whether there was one.
+func (s *Session) Notify(typ, reason string) bool {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn == nil {
+		return false
+	}
+	msg, err := json.Marshal(struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}{typ, reason})
+	if err != nil {
+		return false
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(s.cfg.WS.WriteTimeout))
+	return conn.WriteMessage(websocket.TextMessage, msg) == nil
+}
@@ -0,0 +1,137 @@
+package sessions
+
+import (
+	"encoding/json"
+	"nvimanywhere/internal/config"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var testReaper = &config.Reaper{
+	IdleTimeout:       time.Hour,
+	UnattachedTimeout: 10 * time.Minute,
+	MaxLifetime:       24 * time.Hour,
+	WarnBefore:        time.Minute,
+}
+
+func TestExpiryNeverAttached(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+
+	deadline, reason := s.Expiry(testReaper)
+	want := s.Status().Since[StateReady].Add(testReaper.UnattachedTimeout)
+	if reason != ExpiryUnattached || !deadline.Equal(want) {
+		t.Fatalf("expiry = %v %q, want %v %q", deadline, reason, want, ExpiryUnattached)
+	}
+
+	short := *testReaper
+	short.MaxLifetime = time.Minute
+	if _, reason := s.Expiry(&short); reason != ExpiryLifetime {
+		t.Fatalf("reason = %q, want the lifetime when it comes first", reason)
+	}
+}
+
+func TestExpiryIdleMovesWithInput(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	conn, _ := attachTestSession(t, s)
+	waitFor(t, "attach", func() bool { return s.State() == StateAttached })
+
+	before, reason := s.Expiry(testReaper)
+	if reason != ExpiryIdle {
+		t.Fatalf("reason = %q, want %q once attached", reason, ExpiryIdle)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte("ihello")); err != nil {
+		t.Fatal(err)
+	}
+	readBinary(t, conn)
+	if after, _ := s.Expiry(testReaper); !after.After(before) {
+		t.Fatalf("deadline %v did not move past %v after input", after, before)
+	}
+	if idle := s.IdleFor(); idle > time.Second {
+		t.Fatalf("idle for %v right after input", idle)
+	}
+}
+
+func TestExpirySkipsDisabledLimits(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	attachTestSession(t, s)
+	waitFor(t, "attach", func() bool { return s.State() == StateAttached })
+
+	noIdle := *testReaper
+	noIdle.IdleTimeout = -1
+	deadline, reason := s.Expiry(&noIdle)
+	if want := s.Status().CreatedAt.Add(testReaper.MaxLifetime); reason != ExpiryLifetime || !deadline.Equal(want) {
+		t.Fatalf("expiry = %v %q, want %v %q", deadline, reason, want, ExpiryLifetime)
+	}
+
+	noIdle.MaxLifetime = -1
+	if deadline, reason := s.Expiry(&noIdle); !deadline.IsZero() {
+		t.Fatalf("expiry = %v %q with every limit disabled", deadline, reason)
+	}
+}
+
+func TestExpiryIgnoresClosedSessions(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	s.Close()
+	if deadline, _ := s.Expiry(testReaper); !deadline.IsZero() {
+		t.Fatalf("closed session expires at %v", deadline)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	if s.Notify("warning", "soon") {
+		t.Fatal("Notify reported delivery without a client")
+	}
+
+	conn, _ := attachTestSession(t, s)
+	waitFor(t, "attach", func() bool { return s.Notify("warning", "Session closes in 1m0s: idle") })
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != websocket.TextMessage {
+			continue
+		}
+		var m struct{ Type, Reason string }
+		if err := json.Unmarshal(msg, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Type != "warning" || m.Reason != "Session closes in 1m0s: idle" {
+			t.Fatalf("message = %s", msg)
+		}
+		return
+	}
+}
+
+// Run with -race: the reaper may check a pooled session while the pool
+// hands it out.
+func TestExpiryWhileClaimed(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s.Expiry(testReaper)
+			}
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.claim(map[string]string{"nvimanywhere.owner": "alice"})
+	close(stop)
+	<-done
+
+	deadline, _ := s.Expiry(testReaper)
+	if want := s.Status().CreatedAt.Add(testReaper.MaxLifetime); deadline.After(want) {
+		t.Fatalf("expiry = %v, want no later than the lifetime from the claim", deadline)
+	}
+}
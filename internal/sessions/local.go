@@ -0,0 +1,224 @@
+//go:build unix
+
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"nvimanywhere/internal/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// localRuntime runs nvim directly on the gateway host under a PTY.
+// It is meant for single-user setups where a container per session is overkill.
+type localRuntime struct {
+	binary     string
+	configPath string
+
+	mu    sync.Mutex
+	procs map[string]*localProc
+}
+
+type localProc struct {
+	cmd       *exec.Cmd
+	pty       *os.File
+	startedAt time.Time
+	exited    chan struct{}
+
+	mu   sync.Mutex
+	sink *io.PipeWriter
+}
+
+func newLocalRuntime(cfg *config.SessionRuntime) (*localRuntime, error) {
+	bin, err := exec.LookPath(cfg.NvimBinary)
+	if err != nil {
+		return nil, fmt.Errorf("Find nvim binary: %w", err)
+	}
+	return &localRuntime{
+		binary:     bin,
+		configPath: cfg.NvimConfigPath,
+		procs:      make(map[string]*localProc),
+	}, nil
+}
+
+func (l *localRuntime) Start(ctx context.Context, opts StartOptions) (string, error) {
+	if opts.Network != "" && opts.Network != config.NetworkBridge {
+		return "", fmt.Errorf("Local runtime can't isolate network mode %q", opts.Network)
+	}
+	workspace := opts.Workspace
+	cmd := exec.Command(l.binary, ".")
+	cmd.Dir = workspace
+	cmd.Env = append(os.Environ(),
+		"TERM=xterm-256color",
+		"COLORTERM=truecolor",
+		"NVIM_LOG_FILE="+filepath.Join(workspace, "tmp", "nvim.log"),
+	)
+	if l.configPath != "" {
+		// nvim reads its config from $XDG_CONFIG_HOME/$NVIM_APPNAME.
+		cmd.Env = append(cmd.Env,
+			"XDG_CONFIG_HOME="+filepath.Dir(l.configPath),
+			"NVIM_APPNAME="+filepath.Base(l.configPath),
+		)
+	}
+
+	f, err := pty.StartWithSize(cmd, &pty.Winsize{Cols: 80, Rows: 24})
+	if err != nil {
+		return "", fmt.Errorf("Failed to start nvim: %v", err)
+	}
+
+	p := &localProc{cmd: cmd, pty: f, startedAt: time.Now(), exited: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(p.exited)
+	}()
+	go p.pumpPTY()
+
+	id := strconv.Itoa(cmd.Process.Pid)
+	l.mu.Lock()
+	l.procs[id] = p
+	l.mu.Unlock()
+	return id, nil
+}
+
+// pumpPTY is the only reader of the PTY master. It forwards output to the
+// current attachment and drops it while nobody is attached.
+func (p *localProc) pumpPTY() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := p.pty.Read(buf)
+		if n > 0 {
+			p.mu.Lock()
+			sink := p.sink
+			p.mu.Unlock()
+			if sink != nil {
+				sink.Write(buf[:n])
+			}
+		}
+		if err != nil {
+			p.mu.Lock()
+			if p.sink != nil {
+				p.sink.CloseWithError(err)
+			}
+			p.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (l *localRuntime) Attach(ctx context.Context, id string) (io.Reader, io.Writer, func() error, error) {
+	p, err := l.proc(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pr, pw := io.Pipe()
+	p.mu.Lock()
+	if p.sink != nil {
+		p.sink.Close()
+	}
+	p.sink = pw
+	p.mu.Unlock()
+
+	closeAttach := func() error {
+		p.mu.Lock()
+		if p.sink == pw {
+			p.sink = nil
+		}
+		p.mu.Unlock()
+		pw.Close()
+		return pr.Close()
+	}
+	return pr, p.pty, closeAttach, nil
+}
+
+func (l *localRuntime) Resize(ctx context.Context, id string, cols, rows int) error {
+	p, err := l.proc(id)
+	if err != nil {
+		return err
+	}
+	return pty.Setsize(p.pty, &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)})
+}
+
+func (l *localRuntime) Terminate(ctx context.Context, id string) error {
+	p, err := l.proc(id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		l.mu.Lock()
+		delete(l.procs, id)
+		l.mu.Unlock()
+		p.pty.Close()
+	}()
+
+	// pty.Start puts nvim in its own session, so signal the whole group.
+	pgid := -p.cmd.Process.Pid
+	syscall.Kill(pgid, syscall.SIGHUP)
+
+	select {
+	case <-p.exited:
+		return nil
+	case <-time.After(5 * time.Second):
+	case <-ctx.Done():
+	}
+	syscall.Kill(pgid, syscall.SIGKILL)
+	<-p.exited
+	return ctx.Err()
+}
+
+func (l *localRuntime) Inspect(ctx context.Context, id string) (RuntimeInfo, error) {
+	p, err := l.proc(id)
+	if err != nil {
+		return RuntimeInfo{}, err
+	}
+	info := RuntimeInfo{ID: id, StartedAt: p.startedAt, Running: true}
+	select {
+	case <-p.exited:
+		info.Running = false
+		info.ExitCode = p.cmd.ProcessState.ExitCode()
+	default:
+	}
+	return info, nil
+}
+
+// List returns nothing: local editors are children of the gateway and
+// die with it, so there is never anything to adopt or remove.
+func (l *localRuntime) List(ctx context.Context) ([]RuntimeInfo, error) {
+	return nil, nil
+}
+
+// Exec runs cmd on the host in the workspace. The local runtime has no
+// sandbox, so this is no more isolated than the editor itself.
+func (l *localRuntime) Exec(ctx context.Context, opts StartOptions, cmd ExecCommand) error {
+	if len(cmd.Args) == 0 {
+		return fmt.Errorf("Exec needs a command")
+	}
+	c := exec.CommandContext(ctx, cmd.Args[0], cmd.Args[1:]...)
+	c.Dir = opts.Workspace
+	c.Env = append(os.Environ(), cmd.Env...)
+	c.Stdout = cmd.Stdout
+	c.Stderr = cmd.Stderr
+	return c.Run()
+}
+
+func (l *localRuntime) proc(id string) (*localProc, error) {
+	if id == "" {
+		return nil, containerNotStarted
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, ok := l.procs[id]
+	if !ok {
+		return nil, fmt.Errorf("Local process %s is not found", id)
+	}
+	return p, nil
+}
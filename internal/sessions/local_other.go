@@ -0,0 +1,16 @@
+//go:build !unix
+
+package sessions
+
+import (
+	"errors"
+	"nvimanywhere/internal/config"
+)
+
+type localRuntime struct {
+	Runtime
+}
+
+func newLocalRuntime(cfg *config.SessionRuntime) (*localRuntime, error) {
+	return nil, errors.New("local runtime needs a unix PTY")
+}
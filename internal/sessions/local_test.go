@@ -0,0 +1,91 @@
+//go:build unix
+
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvimanywhere/internal/config"
+)
+
+// fakeNvim writes a stand-in editor that echoes its terminal input.
+func fakeNvim(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nvim")
+	script := "#!/bin/sh\nstty raw -echo\nexec cat\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLocalRuntime(t *testing.T) {
+	l, err := newLocalRuntime(&config.SessionRuntime{NvimBinary: fakeNvim(t)})
+	if err != nil {
+		t.Fatalf("newLocalRuntime: %v", err)
+	}
+	ctx := context.Background()
+
+	id, err := l.Start(ctx, StartOptions{Workspace: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := l.Resize(ctx, id, 100, 30); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+
+	out, in, closeAttach, err := l.Attach(ctx, id)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	defer closeAttach()
+
+	// stty may not have run yet, so keep writing until the echo shows up.
+	got := make(chan []byte, 1)
+	go func() {
+		var all []byte
+		buf := make([]byte, 1024)
+		for {
+			n, err := out.Read(buf)
+			all = append(all, buf[:n]...)
+			if bytes.Contains(all, []byte("ping")) || err != nil {
+				got <- all
+				return
+			}
+		}
+	}()
+	deadline := time.After(3 * time.Second)
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+wait:
+	for {
+		select {
+		case b := <-got:
+			if !bytes.Contains(b, []byte("ping")) {
+				t.Fatalf("output = %q", b)
+			}
+			break wait
+		case <-tick.C:
+			io.WriteString(in, "ping")
+		case <-deadline:
+			t.Fatal("no echo from local process")
+		}
+	}
+
+	info, err := l.Inspect(ctx, id)
+	if err != nil || !info.Running {
+		t.Fatalf("Inspect = %+v, %v; want running", info, err)
+	}
+	if err := l.Terminate(ctx, id); err != nil {
+		t.Fatalf("Terminate: %v", err)
+	}
+	if _, err := l.Inspect(ctx, id); err == nil {
+		t.Fatal("Inspect after Terminate should fail")
+	}
+}
@@ -0,0 +1,162 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"nvimanywhere/internal/config"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// sessionNetworks owns the internal network that isolated sessions join and
+// knows where the egress proxy is reachable from it.
+type sessionNetworks struct {
+	cfg *config.Network
+	cli *client.Client
+
+	mu        sync.Mutex
+	ready     bool
+	gateway   string
+	proxyAddr string
+}
+
+// EgressProxyAddr returns the address the egress proxy listens on:
+// session_runtime.network.proxy.listen when it names a host, or else its
+// port on the internal network's gateway address, which session
+// containers reach and other hosts on the gateway's networks don't.
+func EgressProxyAddr(ctx context.Context, runtime Runtime) (string, error) {
+	runner, ok := runtime.(*dockerRuntime)
+	if !ok {
+		return "", fmt.Errorf("Egress proxy needs a container runtime")
+	}
+	return runner.networks.listenAddr(ctx)
+}
+
+// resolveNetworkMode picks the session's network mode, rejecting modes the
+// admin did not allow.
+func resolveNetworkMode(cfg *config.Network, requested string) (string, error) {
+	if requested == "" {
+		return cfg.Mode, nil
+	}
+	if !cfg.Allows(requested) {
+		return "", fmt.Errorf("%w: network mode %q is not allowed", SessionRequestIsInvalid, requested)
+	}
+	return requested, nil
+}
+
+// fetchNetwork is the network mode git runs on to fetch from or push to
+// the session's repo. It is the session's own mode where that reaches the
+// repo. A session without network still has to clone, so git alone gets
+// bridge; an internal session's git goes out through the egress proxy.
+// Nothing else a session runs gets more than the session's mode.
+func fetchNetwork(mode string) string {
+	switch mode {
+	case config.NetworkNone:
+		return config.NetworkBridge
+	case config.NetworkInternal:
+		return config.NetworkProxy
+	}
+	return mode
+}
+
+func (n *sessionNetworks) apply(ctx context.Context, mode string, cfg *container.Config, hostCfg *container.HostConfig) error {
+	switch mode {
+	case "", config.NetworkBridge:
+		return nil
+	case config.NetworkNone:
+		hostCfg.NetworkMode = container.NetworkMode(network.NetworkNone)
+		return nil
+	case config.NetworkInternal, config.NetworkProxy:
+	default:
+		return fmt.Errorf("Unknown network mode %q", mode)
+	}
+
+	proxyAddr, err := n.ensureInternal(ctx)
+	if err != nil {
+		return err
+	}
+	hostCfg.NetworkMode = container.NetworkMode(n.cfg.InternalNetwork)
+	if mode == config.NetworkProxy {
+		if proxyAddr == "" {
+			return fmt.Errorf("Egress proxy address is unknown, set session_runtime.network.proxy.advertise_addr")
+		}
+		proxy := "http://" + proxyAddr
+		cfg.Env = append(cfg.Env,
+			"HTTP_PROXY="+proxy,
+			"HTTPS_PROXY="+proxy,
+			"http_proxy="+proxy,
+			"https_proxy="+proxy,
+			"NO_PROXY=localhost,127.0.0.1",
+			"no_proxy=localhost,127.0.0.1",
+		)
+	}
+	return nil
+}
+
+func (n *sessionNetworks) listenAddr(ctx context.Context) (string, error) {
+	host, port, err := net.SplitHostPort(n.cfg.Proxy.Listen)
+	if err != nil {
+		return "", fmt.Errorf("Invalid egress proxy address: %w", err)
+	}
+	if host != "" {
+		return n.cfg.Proxy.Listen, nil
+	}
+	if _, err := n.ensureInternal(ctx); err != nil {
+		return "", err
+	}
+	if n.gateway == "" {
+		return "", fmt.Errorf("Network %s has no gateway address, set session_runtime.network.proxy.listen", n.cfg.InternalNetwork)
+	}
+	return net.JoinHostPort(n.gateway, port), nil
+}
+
+// ensureInternal creates the internal network on first use. Internal
+// networks have no route out, so the only way out is the egress proxy.
+func (n *sessionNetworks) ensureInternal(ctx context.Context) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ready {
+		return n.proxyAddr, nil
+	}
+
+	name := n.cfg.InternalNetwork
+	res, err := n.cli.NetworkInspect(ctx, name, network.InspectOptions{})
+	if client.IsErrNotFound(err) {
+		_, err = n.cli.NetworkCreate(ctx, name, network.CreateOptions{
+			Driver:   "bridge",
+			Internal: true,
+			Labels:   map[string]string{"nvimanywhere.managed": "true"},
+		})
+		if err != nil {
+			return "", fmt.Errorf("Create network %s: %w", name, err)
+		}
+		res, err = n.cli.NetworkInspect(ctx, name, network.InspectOptions{})
+	}
+	if err != nil {
+		return "", fmt.Errorf("Inspect network %s: %w", name, err)
+	}
+	if !res.Internal {
+		return "", fmt.Errorf("Network %s exists but is not internal", name)
+	}
+
+	var gateway string
+	if len(res.IPAM.Config) > 0 {
+		gateway = res.IPAM.Config[0].Gateway
+	}
+	proxyAddr := n.cfg.Proxy.AdvertiseAddr
+	if proxyAddr == "" && gateway != "" {
+		// The proxy listens on the host, which is reachable from the
+		// network at its bridge address.
+		_, port, _ := net.SplitHostPort(n.cfg.Proxy.Listen)
+		proxyAddr = net.JoinHostPort(gateway, port)
+	}
+
+	n.ready = true
+	n.gateway = gateway
+	n.proxyAddr = proxyAddr
+	return proxyAddr, nil
+}
@@ -0,0 +1,57 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"nvimanywhere/internal/config"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestResolveNetworkMode(t *testing.T) {
+	cfg := &config.Network{
+		Mode:         config.NetworkProxy,
+		AllowedModes: []string{config.NetworkProxy, config.NetworkNone},
+	}
+	if got, err := resolveNetworkMode(cfg, ""); err != nil || got != config.NetworkProxy {
+		t.Fatalf("default mode = %q, %v", got, err)
+	}
+	if got, err := resolveNetworkMode(cfg, config.NetworkNone); err != nil || got != config.NetworkNone {
+		t.Fatalf("allowed mode = %q, %v", got, err)
+	}
+	if _, err := resolveNetworkMode(cfg, config.NetworkBridge); !errors.Is(err, SessionRequestIsInvalid) {
+		t.Fatalf("disallowed mode err = %v", err)
+	}
+}
+
+func TestNetworkNoneNeedsNoEngine(t *testing.T) {
+	n := &sessionNetworks{cfg: &config.Network{}}
+	cfg, hostCfg := &container.Config{}, &container.HostConfig{}
+	if err := n.apply(context.Background(), config.NetworkNone, cfg, hostCfg); err != nil {
+		t.Fatal(err)
+	}
+	if hostCfg.NetworkMode != "none" {
+		t.Fatalf("NetworkMode = %q", hostCfg.NetworkMode)
+	}
+}
+
+func TestFetchNetwork(t *testing.T) {
+	for mode, want := range map[string]string{
+		config.NetworkBridge:   config.NetworkBridge,
+		config.NetworkNone:     config.NetworkBridge,
+		config.NetworkInternal: config.NetworkProxy,
+		config.NetworkProxy:    config.NetworkProxy,
+	} {
+		if got := fetchNetwork(mode); got != want {
+			t.Errorf("fetchNetwork(%q) = %q, want %q", mode, got, want)
+		}
+	}
+}
+
+func TestEgressProxyListensWhereConfigured(t *testing.T) {
+	n := &sessionNetworks{cfg: &config.Network{Proxy: &config.EgressProxy{Listen: "10.0.0.1:3128"}}}
+	if got, err := n.listenAddr(context.Background()); err != nil || got != "10.0.0.1:3128" {
+		t.Fatalf("listenAddr = %q, %v", got, err)
+	}
+}
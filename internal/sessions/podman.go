@@ -0,0 +1,164 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"nvimanywhere/internal/config"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+)
+
+const podmanSystemSocket = "/run/podman/podman.sock"
+
+// newPodmanRuntime talks to Podman through its Docker-compatible API and
+// adjusts the container spec for rootless user namespaces.
+func newPodmanRuntime(cfg *config.SessionRuntime) (*dockerRuntime, error) {
+	pc := cfg.Podman
+	host, err := discoverPodmanSocket(pc.Socket, os.Getenv, os.Geteuid())
+	if err != nil {
+		return nil, err
+	}
+
+	runner, err := newDockerAPIRuntime(cfg, client.WithHost(host))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	info, err := runner.cli.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Connect to podman at %s: %w", host, err)
+	}
+
+	rootless := isRootless(info.SecurityOptions)
+	runner.specHook = podmanSpecHook(pc, rootless)
+	runner.execUser = podmanExecUser(pc, rootless, os.Getuid(), os.Getgid())
+	return runner, nil
+}
+
+// discoverPodmanSocket returns the API endpoint to use. The rootless
+// per-user socket wins over the system one.
+func discoverPodmanSocket(explicit string, getenv func(string) string, euid int) (string, error) {
+	if explicit != "" {
+		if strings.Contains(explicit, "://") {
+			return explicit, nil
+		}
+		return "unix://" + explicit, nil
+	}
+	if v := getenv("CONTAINER_HOST"); v != "" {
+		return v, nil
+	}
+
+	var candidates []string
+	if dir := getenv("XDG_RUNTIME_DIR"); dir != "" {
+		candidates = append(candidates, filepath.Join(dir, "podman", "podman.sock"))
+	}
+	if euid != 0 {
+		candidates = append(candidates, filepath.Join("/run/user", strconv.Itoa(euid), "podman", "podman.sock"))
+	}
+	candidates = append(candidates, podmanSystemSocket)
+
+	for _, c := range candidates {
+		if st, err := os.Stat(c); err == nil && st.Mode()&os.ModeSocket != 0 {
+			return "unix://" + c, nil
+		}
+	}
+	return "", fmt.Errorf(
+		"Podman socket is not found (tried %s), run `systemctl --user start podman.socket` or set session_runtime.podman.socket",
+		strings.Join(candidates, ", "),
+	)
+}
+
+func isRootless(securityOptions []string) bool {
+	for _, opt := range securityOptions {
+		if strings.Contains(opt, "rootless") {
+			return true
+		}
+	}
+	return false
+}
+
+// podmanUserns picks the user namespace mode. Rootless defaults to keep-id so
+// files in the bind-mounted workspace stay owned by the gateway user on the
+// host, optionally mapped onto the image's editor user.
+func podmanUserns(pc *config.Podman, rootless bool) string {
+	if pc.Userns != "" {
+		return pc.Userns
+	}
+	if !rootless {
+		return ""
+	}
+	if pc.UID > 0 {
+		return fmt.Sprintf("keep-id:uid=%d,gid=%d", pc.UID, pc.GID)
+	}
+	return "keep-id"
+}
+
+// podmanExecUser returns the container user that owns what the gateway
+// user, uid:gid on the host, owns: keep-id maps it onto itself or onto
+// the uid and gid it names, and any other rootless namespace onto root.
+func podmanExecUser(pc *config.Podman, rootless bool, uid, gid int) string {
+	userns := podmanUserns(pc, rootless)
+	if opts, ok := strings.CutPrefix(userns, "keep-id"); ok {
+		for _, opt := range strings.Split(strings.TrimPrefix(opts, ":"), ",") {
+			key, value, _ := strings.Cut(opt, "=")
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "uid":
+				uid = id
+			case "gid":
+				gid = id
+			}
+		}
+	} else if rootless {
+		uid, gid = 0, 0
+	}
+	return fmt.Sprintf("%d:%d", uid, gid)
+}
+
+func podmanSpecHook(pc *config.Podman, rootless bool) func(*container.Config, *container.HostConfig) {
+	userns := podmanUserns(pc, rootless)
+
+	return func(cfg *container.Config, hostCfg *container.HostConfig) {
+		if userns != "" {
+			hostCfg.UsernsMode = container.UsernsMode(userns)
+		}
+		if !pc.SELinuxRelabel {
+			return
+		}
+
+		// The mount API has no relabel option, so bind mounts go through
+		// Binds instead. Only the editor's workspace gets a private :Z
+		// label; the config dir is shared by every session, and Exec
+		// containers mount the workspace next to a running editor, so
+		// everything else gets the shared :z.
+		editor := cfg.Labels[LabelRole] == roleEditor
+		var mounts []mount.Mount
+		for _, m := range hostCfg.Mounts {
+			if m.Type != mount.TypeBind {
+				mounts = append(mounts, m)
+				continue
+			}
+			opts := "z"
+			if editor && m.Target == "/workspace" {
+				opts = "Z"
+			}
+			if m.ReadOnly {
+				opts = "ro," + opts
+			}
+			hostCfg.Binds = append(hostCfg.Binds, m.Source+":"+m.Target+":"+opts)
+		}
+		hostCfg.Mounts = mounts
+	}
+}
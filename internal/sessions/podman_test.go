@@ -0,0 +1,115 @@
+package sessions
+
+import (
+	"net"
+	"nvimanywhere/internal/config"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestDiscoverPodmanSocket(t *testing.T) {
+	runtimeDir := t.TempDir()
+	sock := filepath.Join(runtimeDir, "podman", "podman.sock")
+	if err := os.MkdirAll(filepath.Dir(sock), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer l.Close()
+
+	env := map[string]string{"XDG_RUNTIME_DIR": runtimeDir}
+	getenv := func(k string) string { return env[k] }
+
+	if got, _ := discoverPodmanSocket("", getenv, 1000); got != "unix://"+sock {
+		t.Fatalf("rootless discovery = %q, want %q", got, "unix://"+sock)
+	}
+	if got, _ := discoverPodmanSocket("/tmp/p.sock", getenv, 1000); got != "unix:///tmp/p.sock" {
+		t.Fatalf("explicit socket = %q", got)
+	}
+
+	env["CONTAINER_HOST"] = "unix:///custom.sock"
+	if got, _ := discoverPodmanSocket("", getenv, 1000); got != "unix:///custom.sock" {
+		t.Fatalf("CONTAINER_HOST = %q", got)
+	}
+
+	empty := func(string) string { return "" }
+	if _, err := discoverPodmanSocket("", empty, 0); err == nil {
+		if _, statErr := os.Stat(podmanSystemSocket); statErr != nil {
+			t.Fatal("expected error when no socket exists")
+		}
+	}
+}
+
+func TestPodmanUserns(t *testing.T) {
+	tests := []struct {
+		name     string
+		pc       config.Podman
+		rootless bool
+		want     string
+	}{
+		{"rootful", config.Podman{}, false, ""},
+		{"rootless", config.Podman{}, true, "keep-id"},
+		{"rootless mapped", config.Podman{UID: 1000, GID: 1000}, true, "keep-id:uid=1000,gid=1000"},
+		{"explicit", config.Podman{Userns: "auto"}, true, "auto"},
+	}
+	for _, tt := range tests {
+		if got := podmanUserns(&tt.pc, tt.rootless); got != tt.want {
+			t.Errorf("%s: podmanUserns = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPodmanExecUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		pc       config.Podman
+		rootless bool
+		want     string
+	}{
+		{"rootful", config.Podman{}, false, "1234:1234"},
+		{"rootless", config.Podman{}, true, "1234:1234"},
+		{"rootless mapped", config.Podman{UID: 1000, GID: 1000}, true, "1000:1000"},
+		{"explicit keep-id", config.Podman{Userns: "keep-id:uid=1000"}, true, "1000:1234"},
+		{"explicit other", config.Podman{Userns: "host"}, true, "0:0"},
+	}
+	for _, tt := range tests {
+		if got := podmanExecUser(&tt.pc, tt.rootless, 1234, 1234); got != tt.want {
+			t.Errorf("%s: podmanExecUser = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPodmanSpecHookRelabelsBinds(t *testing.T) {
+	hook := podmanSpecHook(&config.Podman{SELinuxRelabel: true}, true)
+	for role, want := range map[string][]string{
+		roleEditor: {"/ws:/workspace:Z", "/cfg:/home/nvim/.config/nvim:ro,z", "/key:/key:ro,z"},
+		roleExec:   {"/ws:/workspace:z", "/cfg:/home/nvim/.config/nvim:ro,z", "/key:/key:ro,z"},
+	} {
+		cfg := &container.Config{Labels: map[string]string{LabelRole: role}}
+		hostCfg := &container.HostConfig{Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: "/ws", Target: "/workspace"},
+			{Type: mount.TypeBind, Source: "/cfg", Target: "/home/nvim/.config/nvim", ReadOnly: true},
+			{Type: mount.TypeBind, Source: "/key", Target: "/key", ReadOnly: true},
+			{Type: mount.TypeTmpfs, Target: "/tmp"},
+		}}
+
+		hook(cfg, hostCfg)
+
+		if hostCfg.UsernsMode != "keep-id" {
+			t.Fatalf("%s: UsernsMode = %q", role, hostCfg.UsernsMode)
+		}
+		if !slices.Equal(hostCfg.Binds, want) {
+			t.Errorf("%s: Binds = %v, want %v", role, hostCfg.Binds, want)
+		}
+		if len(hostCfg.Mounts) != 1 || hostCfg.Mounts[0].Type != mount.TypeTmpfs {
+			t.Errorf("%s: Mounts = %v, want only tmpfs", role, hostCfg.Mounts)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"nvimanywhere/internal/config"
+	"sync"
+	"time"
+)
+
+// Pool keeps session_runtime.pool.size sessions booted ahead of requests,
+// each with its own token, workspace and running editor, so a request
+// that fits is handed one at once. Pooled sessions are started like any
+// other and only differ in when their clock starts: see claim.
+type Pool struct {
+	ctx     context.Context
+	runtime Runtime
+	cfg     *config.SessionRuntime
+	size    int
+	repo    string
+	network string
+	// onError is told why a pooled session failed to start.
+	onError func(error)
+
+	mu       sync.Mutex
+	idle     []*Session
+	starting int
+	failures int
+	closed   bool
+	wake     chan struct{}
+}
+
+// NewPool returns the pool cfg asks for, or nil when it is disabled. A nil
+// pool never has a session to hand out. It fills once Run is called.
+func NewPool(ctx context.Context, runtime Runtime, cfg *config.SessionRuntime, onError func(error)) (*Pool, error) {
+	if cfg.Pool == nil || cfg.Pool.Size == 0 {
+		return nil, nil
+	}
+	if runtime == nil {
+		return nil, fmt.Errorf("Session runtime is not initialized")
+	}
+	network, err := resolveNetworkMode(cfg.Network, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Pool{
+		ctx:     ctx,
+		runtime: runtime,
+		cfg:     cfg,
+		size:    cfg.Pool.Size,
+		repo:    cfg.Pool.Repo,
+		network: network,
+		onError: onError,
+		wake:    make(chan struct{}, 1),
+	}, nil
+}
+
+// Run keeps the pool full until ctx ends or the pool is closed.
+func (p *Pool) Run() {
+	if p == nil {
+		return
+	}
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		need := p.size - len(p.idle) - p.starting
+		p.starting += max(need, 0)
+		p.mu.Unlock()
+		for range need {
+			go p.fill()
+		}
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-p.wake:
+		}
+	}
+}
+
+// fill boots one session for the pool. A failed attempt keeps its slot
+// for a while, longer each time in a row, so a missing image or an
+// unreachable repo doesn't spin.
+func (p *Pool) fill() {
+	defer p.notify()
+	s, err := p.boot()
+	if err != nil {
+		if s != nil {
+			s.Close()
+		}
+		if p.onError != nil && p.ctx.Err() == nil {
+			p.onError(err)
+		}
+		p.mu.Lock()
+		p.failures++
+		backoff := min(time.Second<<min(p.failures, 6), time.Minute)
+		p.mu.Unlock()
+		select {
+		case <-p.ctx.Done():
+		case <-time.After(backoff):
+		}
+		p.mu.Lock()
+		p.starting--
+		p.mu.Unlock()
+		return
+	}
+
+	p.mu.Lock()
+	p.starting--
+	p.failures = 0
+	closed := p.closed
+	if !closed {
+		p.idle = append(p.idle, s)
+	}
+	p.mu.Unlock()
+	if closed {
+		s.Close()
+	}
+}
+
+func (p *Pool) boot() (*Session, error) {
+	token, err := NewToken()
+	if err != nil {
+		return nil, err
+	}
+	s, err := StartNewSession(p.ctx, p.runtime, p.cfg, token, Options{
+		Repo:   p.repo,
+		Labels: map[string]string{LabelPooled: "true"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to start pooled session: %w", err)
+	}
+	<-s.Booted()
+	if st := s.Status(); st.State != StateReady {
+		return s, fmt.Errorf("Failed to start pooled session: %s", st.Error)
+	}
+	return s, nil
+}
+
+func (p *Pool) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// fits reports whether a request for opts may be served from the pool:
+// it must ask for nothing a pooled session wasn't started with.
+func (p *Pool) fits(opts Options) bool {
+	if opts.Repo != p.repo || opts.Ref != "" || opts.Depth != 0 || len(opts.Sparse) > 0 ||
+		opts.Credentials != nil || opts.Archive != "" || opts.Workspace != "" || opts.Resources != nil {
+		return false
+	}
+	network, err := resolveNetworkMode(p.cfg.Network, opts.Network)
+	return err == nil && network == p.network
+}
+
+// Take hands out an idle session for a request with opts, or returns nil
+// when none fits or the pool is empty; the caller then starts one as
+// usual. The session is the caller's from then on, named by its Token.
+func (p *Pool) Take(opts Options) *Session {
+	if p == nil || !p.fits(opts) {
+		return nil
+	}
+	defer p.notify()
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.idle) == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		s := p.idle[0]
+		p.idle = p.idle[1:]
+		p.mu.Unlock()
+
+		// The editor may have died while it waited.
+		if s.State() == StateReady && !s.Exited() {
+			s.claim(opts.Labels)
+			return s
+		}
+		s.Close()
+	}
+}
+
+// Idle returns how many sessions are ready to be handed out.
+func (p *Pool) Idle() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// Close stops refilling and closes the idle sessions. Sessions still
+// booting are closed as soon as they are ready.
+func (p *Pool) Close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	p.notify()
+
+	var wg sync.WaitGroup
+	for _, s := range idle {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+// claim starts the clock of a pooled session at hand out, so the reaper
+// and the status see it as created and ready just now, and adds the
+// request's labels. Labels only reach the records and not the container,
+// which was labelled at boot: after a restart, only the registry tells a
+// claimed session from an idle one, which is why the pool needs the bolt
+// backend.
+func (s *Session) claim(labels map[string]string) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range labels {
+		s.labels[k] = v
+	}
+	s.repaint = true
+	s.createdAt = now
+	s.updatedAt = now
+	s.detachedAt = now
+	for state := range s.stateSince {
+		s.stateSince[state] = now
+	}
+	s.lastActive.Store(now.UnixNano())
+}
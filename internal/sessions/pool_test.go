@@ -0,0 +1,128 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"nvimanywhere/internal/config"
+	"os"
+	"testing"
+	"time"
+)
+
+func startTestPool(t *testing.T, cfg *config.SessionRuntime, size int) (*Pool, *fakeRuntime) {
+	t.Helper()
+	cfg.Pool = &config.Pool{Size: size}
+	rt := newFakeRuntime()
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := NewPool(ctx, rt, cfg, func(err error) { t.Errorf("pool: %v", err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Run()
+	t.Cleanup(func() {
+		p.Close()
+		cancel()
+	})
+	waitFor(t, "the pool to fill", func() bool { return p.Idle() == size })
+	return p, rt
+}
+
+func TestPoolHandsOutReadySessionsAndRefills(t *testing.T) {
+	cfg := testConfig(t)
+	p, rt := startTestPool(t, cfg, 2)
+
+	before := time.Now()
+	s := p.Take(Options{Labels: map[string]string{"nvimanywhere.owner": "alice"}})
+	if s == nil {
+		t.Fatal("pool handed out nothing")
+	}
+	defer s.Close()
+	st := s.Status()
+	if st.State != StateReady || st.CreatedAt.Before(before) || st.Since[StateReady].Before(before) {
+		t.Fatalf("status = %+v, want ready and created at hand out", st)
+	}
+	if !validToken.MatchString(s.Token()) || s.Label("nvimanywhere.owner") != "alice" {
+		t.Fatalf("token = %q, owner = %q", s.Token(), s.Label("nvimanywhere.owner"))
+	}
+	if p, _ := rt.proc(s.RuntimeID()); p.labels[LabelPooled] != "true" {
+		t.Fatalf("container labels = %v, want pooled", p.labels)
+	}
+
+	waitFor(t, "the pool to refill", func() bool { return p.Idle() == 2 })
+	if n := rt.started(); n != 3 {
+		t.Fatalf("started %d editors, want 3", n)
+	}
+}
+
+func TestPoolOnlyServesRequestsItFits(t *testing.T) {
+	cfg := testConfig(t)
+	p, _ := startTestPool(t, cfg, 1)
+
+	for name, opts := range map[string]Options{
+		"repo":      {Repo: "https://example.com/repo.git"},
+		"archive":   {Archive: "/tmp/upload.tar.gz"},
+		"workspace": {Workspace: "/tmp/named"},
+		"resources": {Resources: &config.Resources{CPUs: 1}},
+		"network":   {Network: config.NetworkNone},
+	} {
+		if s := p.Take(opts); s != nil {
+			s.Close()
+			t.Errorf("%s: pool handed out a session", name)
+		}
+	}
+	if s := p.Take(Options{Network: config.NetworkBridge}); s == nil {
+		t.Fatal("pool handed out nothing for the default network")
+	} else {
+		s.Close()
+	}
+}
+
+func TestPoolDiscardsDeadEditors(t *testing.T) {
+	cfg := testConfig(t)
+	p, rt := startTestPool(t, cfg, 1)
+
+	p.mu.Lock()
+	dead := p.idle[0]
+	p.mu.Unlock()
+	rt.Terminate(context.Background(), dead.RuntimeID())
+
+	if s := p.Take(Options{}); s != nil {
+		s.Close()
+		t.Fatal("pool handed out a session whose editor had exited")
+	}
+	if _, err := os.Stat(dead.Workspace()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("dead pooled session kept its workspace")
+	}
+}
+
+func TestPoolCloseClosesIdleSessions(t *testing.T) {
+	cfg := testConfig(t)
+	p, rt := startTestPool(t, cfg, 2)
+
+	p.mu.Lock()
+	idle := append([]*Session(nil), p.idle...)
+	p.mu.Unlock()
+	p.Close()
+
+	for _, s := range idle {
+		if proc, _ := rt.proc(s.RuntimeID()); !proc.isTerminated() {
+			t.Error("closing the pool left an editor running")
+		}
+	}
+	if s := p.Take(Options{}); s != nil {
+		t.Fatal("closed pool handed out a session")
+	}
+}
+
+func TestDisabledPoolIsNil(t *testing.T) {
+	cfg := testConfig(t)
+	p, err := NewPool(context.Background(), newFakeRuntime(), cfg, nil)
+	if err != nil || p != nil {
+		t.Fatalf("pool = %v, err = %v, want nil", p, err)
+	}
+	if s := p.Take(Options{}); s != nil || p.Idle() != 0 {
+		t.Fatal("nil pool handed out a session")
+	}
+	p.Run()
+	p.Close()
+}
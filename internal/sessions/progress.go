@@ -0,0 +1,68 @@
+package sessions
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CloneProgress is the latest progress line git reported during a clone.
+type CloneProgress struct {
+	Phase   string `json:"phase"`
+	Percent int    `json:"percent"`
+}
+
+// progressLine matches git's --progress output, for example
+// "Receiving objects:  45% (450/1000), 1.2 MiB | 2.3 MiB/s".
+var progressLine = regexp.MustCompile(`^(?:remote: )?([A-Za-z ]+):\s+(\d{1,3})%`)
+
+// maxCloneLog bounds the non-progress stderr kept for error messages.
+const maxCloneLog = 8 * 1024
+
+// progressWriter parses git's stderr as it is written. Progress updates
+// are overwritten in place with '\r', so lines are split on both '\r' and
+// '\n'. Everything else is kept for the error message.
+type progressWriter struct {
+	report  func(CloneProgress)
+	partial []byte
+	log     bytes.Buffer
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexAny(w.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.line(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *progressWriter) line(l string) {
+	l = strings.TrimSpace(l)
+	if l == "" {
+		return
+	}
+	if m := progressLine.FindStringSubmatch(l); m != nil {
+		pct, _ := strconv.Atoi(m[2])
+		w.report(CloneProgress{Phase: strings.ToLower(strings.TrimSpace(m[1])), Percent: pct})
+		return
+	}
+	if w.log.Len() < maxCloneLog {
+		w.log.WriteString(l)
+		w.log.WriteByte('\n')
+	}
+}
+
+// String returns the non-progress output, including an unterminated last line.
+func (w *progressWriter) String() string {
+	if len(w.partial) > 0 {
+		w.line(string(w.partial))
+		w.partial = nil
+	}
+	return w.log.String()
+}
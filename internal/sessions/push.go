@@ -0,0 +1,210 @@
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PushRequest describes the commit made from the workspace and where it goes.
+type PushRequest struct {
+	// Branch is created on the remote; it must not exist yet. A name under
+	// nvimanywhere/ is picked when it is empty.
+	Branch      string `json:"branch"`
+	Message     string `json:"message"`
+	AuthorName  string `json:"author_name"`
+	AuthorEmail string `json:"author_email"`
+}
+
+// PushResult is what ended up on the remote.
+type PushResult struct {
+	Branch string `json:"branch"`
+	Commit string `json:"commit"`
+}
+
+// defaultBranchPrefix names branches pushed without an explicit name.
+const defaultBranchPrefix = "nvimanywhere/"
+
+func (r *PushRequest) validate(now time.Time) error {
+	if strings.TrimSpace(r.Message) == "" {
+		return fmt.Errorf("%w: a commit message is required", SessionRequestIsInvalid)
+	}
+	if strings.ContainsRune(r.Message, 0) {
+		return fmt.Errorf("%w: the commit message contains invalid characters", SessionRequestIsInvalid)
+	}
+	if strings.TrimSpace(r.AuthorName) == "" || strings.ContainsAny(r.AuthorName, "<>\n\r\x00") {
+		return fmt.Errorf("%w: author_name is missing or invalid", SessionRequestIsInvalid)
+	}
+	if addr, err := mail.ParseAddress(r.AuthorEmail); err != nil || addr.Address != r.AuthorEmail {
+		return fmt.Errorf("%w: author_email is missing or invalid", SessionRequestIsInvalid)
+	}
+	if r.Branch == "" {
+		r.Branch = defaultBranchPrefix + now.UTC().Format("20060102-150405")
+	}
+	r.Branch = strings.TrimPrefix(r.Branch, "refs/heads/")
+	if strings.HasPrefix(r.Branch, "refs/") || r.Branch == "HEAD" {
+		return fmt.Errorf("%w: branch %q is not a branch name", SessionRequestIsInvalid, r.Branch)
+	}
+	if err := validateRef(r.Branch); err != nil {
+		return fmt.Errorf("%w: %v", SessionRequestIsInvalid, err)
+	}
+	return nil
+}
+
+// Push commits every change in the workspace and pushes the result to a
+// new branch of the repository the session was cloned from, with the
+// credentials it was created with.
+//
+// The workspace, .git/config included, is under the user's control, so
+// git never runs there with the credentials. The commit is made in the
+// workspace without them, and the push from a scratch repository that
+// borrows the workspace's objects through alternates.
+func (s *Session) Push(ctx context.Context, req PushRequest) (PushResult, error) {
+	s.mu.Lock()
+	base, err := s.baseCommit, stateErr(s.state)
+	s.mu.Unlock()
+	if err != nil {
+		return PushResult{}, err
+	}
+	if s.repoUrl == "" || base == "" {
+		return PushResult{}, SessionIsNotARepo
+	}
+	if err := req.validate(time.Now()); err != nil {
+		return PushResult{}, err
+	}
+
+	s.pushMu.Lock()
+	defer s.pushMu.Unlock()
+
+	head, err := s.commitWorkspace(ctx, req)
+	if err != nil {
+		return PushResult{}, err
+	}
+	if head == base {
+		return PushResult{}, SessionHasNoChanges
+	}
+	if err := s.pushCommit(ctx, head, req.Branch); err != nil {
+		return PushResult{}, err
+	}
+	return PushResult{Branch: req.Branch, Commit: head}, nil
+}
+
+// commitWorkspace commits all changes, if there are any, and returns HEAD.
+func (s *Session) commitWorkspace(ctx context.Context, req PushRequest) (string, error) {
+	git := func(stdout io.Writer, args ...string) error {
+		stderr := &bytes.Buffer{}
+		err := s.runtime.Exec(ctx, s.opts, ExecCommand{
+			Args: append([]string{"git", "-c", "core.hooksPath=/dev/null", "-c", "commit.gpgSign=false"}, args...),
+			Env: []string{
+				"GIT_TERMINAL_PROMPT=0",
+				"GIT_AUTHOR_NAME=" + req.AuthorName,
+				"GIT_AUTHOR_EMAIL=" + req.AuthorEmail,
+				"GIT_COMMITTER_NAME=" + req.AuthorName,
+				"GIT_COMMITTER_EMAIL=" + req.AuthorEmail,
+			},
+			Stdout: stdout,
+			Stderr: stderr,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to commit: git %s: %v, %s", args[0], err, stderr.String())
+		}
+		return nil
+	}
+
+	if err := git(nil, "add", "-A"); err != nil {
+		return "", err
+	}
+	status := &bytes.Buffer{}
+	if err := git(status, "status", "--porcelain"); err != nil {
+		return "", err
+	}
+	if status.Len() > 0 {
+		if err := git(nil, "commit", "-q", "--no-verify", "-m", req.Message); err != nil {
+			return "", err
+		}
+	}
+	head := &bytes.Buffer{}
+	if err := git(head, "rev-parse", "--verify", "HEAD"); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(head.String()), nil
+}
+
+// pushScratch sets up a bare repository that reads objects, and the shallow
+// boundary of a depth-limited clone, from the workspace given as $1.
+const pushScratch = `set -e
+git init -q --bare
+echo "$1/.git/objects" > objects/info/alternates
+if [ -f "$1/.git/shallow" ]; then cp "$1/.git/shallow" shallow; fi`
+
+func (s *Session) pushCommit(ctx context.Context, commit, branch string) error {
+	// The URL is checked again: what its host resolves to may have changed.
+	target, err := checkRepo(ctx, s.cfg.Repos, s.repoUrl)
+	if err != nil {
+		return fmt.Errorf("Failed to push: %v", err)
+	}
+	workspace, err := filepath.Abs(s.rootPath)
+	if err != nil {
+		return err
+	}
+	base, err := filepath.Abs(s.cfg.BasePath)
+	if err != nil {
+		return err
+	}
+	scratch, err := os.MkdirTemp(base, ".push-*")
+	if err != nil {
+		return fmt.Errorf("Failed to create push dir: %v", err)
+	}
+	defer os.RemoveAll(scratch)
+	if err := os.Chmod(scratch, 0o755); err != nil {
+		return err
+	}
+
+	auth := gitAuth{cleanup: func() {}}
+	if s.creds != nil {
+		if auth, err = s.creds.gitAuth(s.repoUrl, s.cfg.SSHKnownHosts, s.cfg.BasePath); err != nil {
+			return err
+		}
+	}
+	defer auth.cleanup()
+
+	opts := s.opts
+	opts.Workspace = scratch
+	stderr := &bytes.Buffer{}
+	err = s.runtime.Exec(ctx, opts, ExecCommand{
+		Args:   []string{"sh", "-c", pushScratch, "sh", workspace},
+		Mounts: []string{workspace},
+		Stderr: stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to prepare push: %v, %s", err, stderr.String())
+	}
+
+	gitArgs := append([]string{"git"}, target.gitConfig(s.cfg.Repos)...)
+	gitArgs = append(gitArgs, auth.config...)
+	gitArgs = append(gitArgs,
+		"-c", "core.hooksPath=/dev/null",
+		// An empty lease only lets the push create the branch.
+		"push", "--porcelain", "--force-with-lease=refs/heads/"+branch+":",
+		"--", s.repoUrl, commit+":refs/heads/"+branch,
+	)
+	stderr.Reset()
+	err = s.runtime.Exec(ctx, opts, ExecCommand{
+		Args:    gitArgs,
+		Env:     target.gitEnv(auth),
+		Mounts:  append([]string{workspace}, auth.mounts...),
+		Network: fetchNetwork(opts.Network),
+		Stdout:  stderr,
+		Stderr:  stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v, %s", SessionPushIsRejected, err, auth.redact(stderr.String()))
+	}
+	return nil
+}
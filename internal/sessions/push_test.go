@@ -0,0 +1,130 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testPush = PushRequest{
+	Branch:      "fix/typo",
+	Message:     "Fix the typo",
+	AuthorName:  "Ada Lovelace",
+	AuthorEmail: "ada@example.com",
+}
+
+func pushSession(t *testing.T, repo string, creds *Credentials) *Session {
+	t.Helper()
+	s, err := StartNewSession(context.Background(), newFakeRuntime(), testConfig(t), "token",
+		Options{Repo: repo, Credentials: creds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+	if st := s.Status(); st.State != StateReady {
+		t.Fatalf("status = %+v, want ready", st)
+	}
+	return s
+}
+
+func TestPushCreatesBranch(t *testing.T) {
+	repo := testRepo(t)
+	s := pushSession(t, repo, nil)
+	writeFile(t, filepath.Join(s.rootPath, "main.go"), "package main\n\nfunc main() {}\n")
+	writeFile(t, filepath.Join(s.rootPath, "new.go"), "package main\n")
+
+	res, err := s.Push(context.Background(), testPush)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Branch != "fix/typo" || res.Commit == "" {
+		t.Fatalf("result = %+v", res)
+	}
+
+	origin := strings.TrimPrefix(repo, "file://")
+	if got := runGit(t, origin, "rev-parse", "refs/heads/fix/typo"); got != res.Commit {
+		t.Fatalf("remote branch is at %s, want %s", got, res.Commit)
+	}
+	if got := runGit(t, origin, "log", "-1", "--format=%an <%ae> %s", res.Commit); got != "Ada Lovelace <ada@example.com> Fix the typo" {
+		t.Errorf("commit = %q", got)
+	}
+	if got := runGit(t, origin, "show", res.Commit+":new.go"); got != "package main" {
+		t.Errorf("new.go = %q", got)
+	}
+
+	// The branch must be new, so a second push to it is refused.
+	writeFile(t, filepath.Join(s.rootPath, "more.go"), "package main\n")
+	if _, err := s.Push(context.Background(), testPush); !errors.Is(err, SessionPushIsRejected) {
+		t.Fatalf("second push: err = %v, want SessionPushIsRejected", err)
+	}
+
+	entries, _ := os.ReadDir(s.cfg.BasePath)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".push-") {
+			t.Errorf("push dir %s left behind", e.Name())
+		}
+	}
+}
+
+func TestPushDefaultBranch(t *testing.T) {
+	s := pushSession(t, testRepo(t), nil)
+	writeFile(t, filepath.Join(s.rootPath, "new.go"), "package main\n")
+
+	req := testPush
+	req.Branch = ""
+	res, err := s.Push(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(res.Branch, defaultBranchPrefix) {
+		t.Fatalf("branch = %q, want one under %s", res.Branch, defaultBranchPrefix)
+	}
+}
+
+func TestPushWithToken(t *testing.T) {
+	const token = "s3cr3t-token"
+	repo := privateRepo(t, defaultTokenUsername, token)
+	s := pushSession(t, repo, &Credentials{Token: token})
+	writeFile(t, filepath.Join(s.rootPath, "new.go"), "package main\n")
+
+	if _, err := s.Push(context.Background(), testPush); err != nil {
+		t.Fatal(err)
+	}
+	gitConfig, err := os.ReadFile(filepath.Join(s.rootPath, ".git", "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(gitConfig), token) {
+		t.Fatalf("token written into the workspace:\n%s", gitConfig)
+	}
+}
+
+func TestPushRejects(t *testing.T) {
+	s := pushSession(t, testRepo(t), nil)
+	if _, err := s.Push(context.Background(), testPush); !errors.Is(err, SessionHasNoChanges) {
+		t.Errorf("no changes: err = %v, want SessionHasNoChanges", err)
+	}
+
+	for name, mutate := range map[string]func(*PushRequest){
+		"no message":   func(r *PushRequest) { r.Message = " " },
+		"no author":    func(r *PushRequest) { r.AuthorName = "" },
+		"bad email":    func(r *PushRequest) { r.AuthorEmail = "Ada <ada@example.com>" },
+		"option ref":   func(r *PushRequest) { r.Branch = "-f" },
+		"not a branch": func(r *PushRequest) { r.Branch = "refs/tags/v1" },
+		"bad ref":      func(r *PushRequest) { r.Branch = "a..b" },
+	} {
+		req := testPush
+		mutate(&req)
+		if _, err := s.Push(context.Background(), req); !errors.Is(err, SessionRequestIsInvalid) {
+			t.Errorf("%s: err = %v, want SessionRequestIsInvalid", name, err)
+		}
+	}
+
+	plain, _ := startTestSession(t, testConfig(t))
+	if _, err := plain.Push(context.Background(), testPush); !errors.Is(err, SessionIsNotARepo) {
+		t.Errorf("plain workspace: err = %v, want SessionIsNotARepo", err)
+	}
+}
@@ -0,0 +1,242 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"nvimanywhere/internal/config"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Labels put on every container a session creates, so a restarted gateway
+// can tell which are its own and what they belong to.
+const (
+	LabelGateway    = "nvimanywhere.gateway"
+	LabelSession    = "nvimanywhere.session"
+	LabelRole       = "nvimanywhere.role"
+	LabelWorkspace  = "nvimanywhere.workspace"
+	LabelPersistent = "nvimanywhere.persistent"
+	LabelCreated    = "nvimanywhere.created"
+	LabelRepo       = "nvimanywhere.repo"
+	LabelNetwork    = "nvimanywhere.network"
+	// LabelResources holds the resolved limits as JSON, so an adopted
+	// session's execs run under the limits its editor was started with.
+	LabelResources  = "nvimanywhere.resources"
+	LabelBaseCommit = "nvimanywhere.base-commit"
+	// LabelPooled marks sessions started by a Pool ahead of any request.
+	LabelPooled = "nvimanywhere.pooled"
+
+	// roleEditor runs nvim; roleExec runs a clone, hook or push.
+	roleEditor = "editor"
+	roleExec   = "exec"
+)
+
+// validToken matches NewToken's output. Only directories named like it
+// are ever swept from base_path.
+var validToken = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// NewToken returns a random session token, which also names the session's
+// workspace directory.
+func NewToken() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// tempPrefixes name the scratch files and dirs sessions keep in base_path
+// while an upload, clone or push is in flight.
+var tempPrefixes = []string{".upload-", ".deploy-key-", ".push-"}
+
+// runtimeLabels describes the session for the containers it creates.
+func (s *Session) runtimeLabels() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	labels := make(map[string]string, len(s.labels)+8)
+	for k, v := range s.labels {
+		labels[k] = v
+	}
+	labels[LabelGateway] = s.cfg.GatewayID
+	labels[LabelSession] = s.token
+	labels[LabelWorkspace] = s.rootPath
+	labels[LabelCreated] = s.createdAt.UTC().Format(time.RFC3339Nano)
+	labels[LabelNetwork] = s.opts.Network
+	if res, err := json.Marshal(s.opts.Resources); err == nil {
+		labels[LabelResources] = string(res)
+	}
+	if s.persistent {
+		labels[LabelPersistent] = "true"
+	}
+	if s.repoUrl != "" {
+		labels[LabelRepo] = RedactRepo(s.repoUrl)
+	}
+	if s.baseCommit != "" {
+		labels[LabelBaseCommit] = s.baseCommit
+	}
+	return labels
+}
+
+// RedactRepo drops credentials from the repo URL: passwords, and user
+// names of http(s) URLs, which are often tokens. It is what goes into
+// container labels and session records, which outlive the credentials.
+func RedactRepo(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil || u.User == nil || !strings.Contains(repo, "://") {
+		return repo
+	}
+	_, hasPassword := u.User.Password()
+	if hasPassword || u.Scheme == "http" || u.Scheme == "https" {
+		u.User = nil
+	}
+	return u.String()
+}
+
+// Reconcile brings the runtime and base_path back in line with a gateway
+// that just started and knows no sessions. Editors that still run are
+// adopted and returned; anything else this gateway left behind, from
+// stopped or half-created containers to workspaces and scratch files of
+// sessions that are gone, is removed. It must run before the gateway
+// creates sessions of its own.
+func Reconcile(ctx context.Context, runtime Runtime, cfg *config.SessionRuntime) ([]*Session, error) {
+	if runtime == nil {
+		return nil, fmt.Errorf("Session runtime is not initialized")
+	}
+	infos, err := runtime.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list sessions: %w", err)
+	}
+
+	var (
+		adopted []*Session
+		keep    = make(map[string]bool)
+		errs    []error
+	)
+	for _, info := range infos {
+		if info.Labels[LabelRole] == roleEditor && info.Running {
+			// One that can't be adopted is removed like any other leftover.
+			if s, err := adopt(ctx, runtime, cfg, info); err == nil {
+				adopted = append(adopted, s)
+				keep[filepath.Clean(s.rootPath)] = true
+				continue
+			}
+		}
+		if err := runtime.Terminate(ctx, info.ID); err != nil {
+			errs = append(errs, fmt.Errorf("Failed to remove %s: %w", info.ID, err))
+		}
+	}
+	if err := sweepBasePath(cfg.BasePath, keep); err != nil {
+		errs = append(errs, err)
+	}
+	return adopted, errors.Join(errs...)
+}
+
+// adopt rebuilds a detached session around an editor that survived the
+// gateway. Scrollback and credentials lived in the old gateway's memory
+// and are gone; the first attach makes nvim repaint instead.
+func adopt(ctx context.Context, runtime Runtime, cfg *config.SessionRuntime, info RuntimeInfo) (*Session, error) {
+	labels := info.Labels
+	token := labels[LabelSession]
+	if !validToken.MatchString(token) {
+		return nil, fmt.Errorf("session token %q is invalid", token)
+	}
+	workspace := labels[LabelWorkspace]
+	if fi, err := os.Stat(workspace); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("workspace %q is gone", workspace)
+	}
+	var res config.Resources
+	if err := json.Unmarshal([]byte(labels[LabelResources]), &res); err != nil {
+		// Editors started before the label existed ran with the defaults.
+		if res, err = resolveResources(cfg, nil); err != nil {
+			return nil, err
+		}
+	}
+	created, err := time.Parse(time.RFC3339Nano, labels[LabelCreated])
+	if err != nil {
+		created = info.StartedAt
+	}
+
+	s := newSession(ctx, runtime, cfg, token, workspace, created)
+	s.repoUrl = labels[LabelRepo]
+	s.persistent = labels[LabelPersistent] == "true"
+	s.reopened, s.populated, s.repaint = true, true, true
+	s.baseCommit = labels[LabelBaseCommit]
+	s.runtimeId = info.ID
+	s.opts = StartOptions{Workspace: workspace, Resources: res, Network: labels[LabelNetwork]}
+	for k, v := range labels {
+		if !strings.HasPrefix(k, "nvimanywhere.") || k == LabelRole {
+			continue
+		}
+		s.labels[k] = v
+	}
+
+	now := time.Now()
+	s.state = StateDetached
+	s.stateSince[StateReady] = now
+	s.stateSince[StateDetached] = now
+	s.updatedAt = now
+	s.detachedAt = now
+	s.lastActive.Store(now.UnixNano())
+	close(s.booted)
+	return s, nil
+}
+
+// sweepBasePath removes session workspaces not in keep, and scratch files
+// left by interrupted uploads, clones and pushes. Anything not named like
+// either is left alone.
+func sweepBasePath(basePath string, keep map[string]bool) error {
+	entries, err := os.ReadDir(basePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to read base path: %w", err)
+	}
+	var errs []error
+	for _, e := range entries {
+		path := filepath.Join(basePath, e.Name())
+		orphan := e.IsDir() && validToken.MatchString(e.Name()) && !keep[filepath.Clean(path)]
+		for _, prefix := range tempPrefixes {
+			orphan = orphan || strings.HasPrefix(e.Name(), prefix)
+		}
+		if !orphan {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			errs = append(errs, fmt.Errorf("Failed to remove %s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Token returns the token the session is known by.
+func (s *Session) Token() string {
+	return s.token
+}
+
+// RuntimeID returns the id of the editor process, empty until it started.
+func (s *Session) RuntimeID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runtimeId
+}
+
+// Workspace returns the directory the session works in.
+func (s *Session) Workspace() string {
+	return s.rootPath
+}
+
+// Label returns a label the session was started with, or adopted with.
+func (s *Session) Label(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.labels[key]
+}
@@ -0,0 +1,200 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"nvimanywhere/internal/config"
+	"nvimanywhere/internal/egress"
+	"slices"
+	"strings"
+	"time"
+)
+
+// repoLookupTimeout bounds the DNS lookup done while validating a repo.
+const repoLookupTimeout = 5 * time.Second
+
+// lookupIP is replaced in tests.
+var lookupIP = net.DefaultResolver.LookupNetIP
+
+// repoTarget is where a validated repo URL points.
+type repoTarget struct {
+	scheme string
+	host   string
+	port   string
+	// addr is the address the host resolved to during validation; git is
+	// pinned to it so a second lookup can't be answered differently.
+	addr netip.Addr
+}
+
+// nonPublic are ranges netip does not count as private that still must
+// not be reached: "this network", which Linux connects to the local host,
+// the shared address space of RFC 6598, and the benchmarking range of
+// RFC 2544.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// checkRepo validates a user-supplied repo URL against the policy and,
+// unless private addresses are allowed, resolves its host and rejects
+// anything that isn't a public address.
+func checkRepo(ctx context.Context, policy *config.Repos, repo string) (repoTarget, error) {
+	t, err := parseRepo(repo)
+	if err != nil {
+		return repoTarget{}, err
+	}
+	if !slices.Contains(policy.AllowedSchemes, t.scheme) {
+		return repoTarget{}, fmt.Errorf("repo scheme %q is not allowed", t.scheme)
+	}
+	if t.scheme == "file" {
+		return t, nil
+	}
+
+	if egress.MatchHost(policy.DeniedHosts, t.host) {
+		return repoTarget{}, fmt.Errorf("repo host %q is not allowed", t.host)
+	}
+	if len(policy.AllowedHosts) > 0 && !egress.MatchHost(policy.AllowedHosts, t.host) {
+		return repoTarget{}, fmt.Errorf("repo host %q is not allowed", t.host)
+	}
+	if policy.AllowPrivateAddresses {
+		return t, nil
+	}
+	// Unlike HTTP and SSH, git:// can't be pinned to the address checked
+	// below, so a second lookup could lead anywhere.
+	if t.scheme == "git" {
+		return repoTarget{}, fmt.Errorf("repo scheme %q needs allow_private_addresses", t.scheme)
+	}
+
+	var addrs []netip.Addr
+	if ip, err := netip.ParseAddr(t.host); err == nil {
+		addrs = []netip.Addr{ip}
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, repoLookupTimeout)
+		defer cancel()
+		if addrs, err = lookupIP(ctx, "ip", t.host); err != nil || len(addrs) == 0 {
+			return repoTarget{}, fmt.Errorf("repo host %q does not resolve", t.host)
+		}
+	}
+	for _, addr := range addrs {
+		if !isPublicAddr(addr) {
+			return repoTarget{}, fmt.Errorf("repo host %q resolves to a non-public address", t.host)
+		}
+	}
+	t.addr = addrs[0].Unmap()
+	return t, nil
+}
+
+// parseRepo understands the URL forms git clone takes from users: URLs
+// with a scheme and scp-like user@host:path. Local paths and the
+// <transport>::<address> syntax (ext::, fd::) are refused outright.
+func parseRepo(repo string) (repoTarget, error) {
+	invalid := fmt.Errorf("repo %q is not a valid repository URL", repo)
+	if repo == "" || strings.HasPrefix(repo, "-") || strings.ContainsAny(repo, " \t\r\n\x00") {
+		return repoTarget{}, invalid
+	}
+	if strings.Contains(repo, "::") && !strings.Contains(repo, "://") {
+		return repoTarget{}, fmt.Errorf("repo %q uses a git remote helper", repo)
+	}
+
+	if !strings.Contains(repo, "://") {
+		// scp-like syntax: [user@]host:path, where host has no slash.
+		colon := strings.Index(repo, ":")
+		if colon <= 0 || strings.Contains(repo[:colon], "/") {
+			return repoTarget{}, invalid
+		}
+		host := repo[:colon]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" || strings.HasPrefix(host, "-") {
+			return repoTarget{}, invalid
+		}
+		return repoTarget{scheme: "ssh", host: strings.ToLower(host), port: "22"}, nil
+	}
+
+	u, err := url.Parse(repo)
+	if err != nil {
+		return repoTarget{}, invalid
+	}
+	scheme := strings.ToLower(u.Scheme)
+	switch scheme {
+	case "git+ssh", "ssh+git":
+		scheme = "ssh"
+	}
+	t := repoTarget{scheme: scheme, host: strings.ToLower(u.Hostname()), port: u.Port()}
+	if scheme == "file" {
+		return t, nil
+	}
+	if t.host == "" || strings.HasPrefix(t.host, "-") {
+		return repoTarget{}, invalid
+	}
+	if t.port == "" {
+		t.port = map[string]string{"https": "443", "http": "80", "ssh": "22", "git": "9418"}[scheme]
+	}
+	return t, nil
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublic {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// gitConfig returns -c options that keep git to the validated target: only
+// the allowed transports, and for HTTP the address checked above with no
+// redirects that could lead somewhere else. SSH is pinned by gitEnv.
+func (t repoTarget) gitConfig(policy *config.Repos) []string {
+	args := []string{"-c", "protocol.allow=never"}
+	for _, scheme := range policy.AllowedSchemes {
+		args = append(args, "-c", "protocol."+scheme+".allow=always")
+	}
+	if t.addr.IsValid() && (t.scheme == "https" || t.scheme == "http") {
+		addr := t.addr.String()
+		if t.addr.Is6() {
+			addr = "[" + addr + "]"
+		}
+		args = append(args,
+			"-c", "http.curloptResolve="+t.host+":"+t.port+":"+addr,
+			"-c", "http.followRedirects=false",
+		)
+	}
+	return args
+}
+
+// gitEnv returns the environment git runs with against t, authenticated
+// with auth. SSH is pinned like HTTP: ssh connects to the address checked
+// above and still verifies the host key known for the host name.
+func (t repoTarget) gitEnv(auth gitAuth) []string {
+	env := append([]string{"GIT_TERMINAL_PROMPT=0"}, auth.env...)
+	ssh := slices.Clip(auth.ssh)
+	if t.addr.IsValid() && t.scheme == "ssh" {
+		alias := t.host
+		if t.port != "22" {
+			// known_hosts keeps hosts on other ports as [host]:port.
+			alias = "[" + t.host + "]:" + t.port
+		}
+		ssh = append(ssh, "-o", "HostName="+t.addr.String(), "-o", "HostKeyAlias="+shellQuote(alias))
+	}
+	if len(ssh) > 0 {
+		env = append(env, "GIT_SSH_COMMAND=ssh "+strings.Join(ssh, " "))
+	}
+	return env
+}
+
+// allowProtocol is GIT_ALLOW_PROTOCOL for git processes started after the
+// clone, such as submodule updates in post-clone hooks.
+func allowProtocol(policy *config.Repos) string {
+	return "GIT_ALLOW_PROTOCOL=" + strings.Join(policy.AllowedSchemes, ":")
+}
@@ -0,0 +1,178 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"nvimanywhere/internal/config"
+	"slices"
+	"testing"
+)
+
+func stubLookup(t *testing.T, hosts map[string]string) {
+	t.Helper()
+	orig := lookupIP
+	lookupIP = func(_ context.Context, _, host string) ([]netip.Addr, error) {
+		addr, ok := hosts[host]
+		if !ok {
+			return nil, errors.New("no such host")
+		}
+		return []netip.Addr{netip.MustParseAddr(addr)}, nil
+	}
+	t.Cleanup(func() { lookupIP = orig })
+}
+
+func TestCheckRepo(t *testing.T) {
+	stubLookup(t, map[string]string{
+		"github.com":        "140.82.121.4",
+		"git.example.com":   "203.0.113.10",
+		"rebind.example":    "10.0.0.5",
+		"metadata.internal": "169.254.169.254",
+		"v6.example.com":    "::ffff:127.0.0.1",
+	})
+	policy := &config.Repos{
+		AllowedSchemes: []string{"https", "ssh"},
+		DeniedHosts:    []string{"*.corp.example"},
+	}
+
+	allowed := []string{
+		"https://github.com/org/repo.git",
+		"https://GitHub.com/org/repo",
+		"ssh://git@github.com/org/repo.git",
+		"git@github.com:org/repo.git",
+		"https://git.example.com:8443/repo.git",
+	}
+	for _, repo := range allowed {
+		if _, err := checkRepo(context.Background(), policy, repo); err != nil {
+			t.Errorf("checkRepo(%q) = %v, want allowed", repo, err)
+		}
+	}
+
+	denied := []string{
+		"file:///etc",
+		"/srv/repos/app.git",
+		"../app",
+		"ext::sh -c touch% /tmp/pwned",
+		"fd::3",
+		"http://github.com/org/repo.git",
+		"git://github.com/org/repo.git",
+		"https://127.0.0.1/repo.git",
+		"https://[::1]/repo.git",
+		"https://10.1.2.3/repo.git",
+		"https://100.64.0.1/repo.git",
+		"https://0.0.0.0/repo.git",
+		"https://198.18.0.1/repo.git",
+		"https://rebind.example/repo.git",
+		"https://metadata.internal/latest",
+		"https://v6.example.com/repo.git",
+		"https://nowhere.invalid/repo.git",
+		"https://git.corp.example/repo.git",
+		"ssh://-oProxyCommand=touch%20x/repo",
+		"-uhttps://github.com/org/repo",
+		"https://github.com/org/repo .git",
+	}
+	for _, repo := range denied {
+		if _, err := checkRepo(context.Background(), policy, repo); err == nil {
+			t.Errorf("checkRepo(%q) allowed, want denied", repo)
+		}
+	}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"140.82.121.4":     true,
+		"2606:4700::1111":  true,
+		"0.0.0.0":          false,
+		"0.1.2.3":          false,
+		"::ffff:0.1.2.3":   false,
+		"10.1.2.3":         false,
+		"100.64.0.1":       false,
+		"127.0.0.1":        false,
+		"169.254.169.254":  false,
+		"198.18.0.1":       false,
+		"198.19.255.254":   false,
+		"198.20.0.1":       true,
+		"::1":              false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := isPublicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCheckRepoAllowedHosts(t *testing.T) {
+	stubLookup(t, map[string]string{"github.com": "140.82.121.4", "gitlab.com": "172.65.251.78"})
+	policy := &config.Repos{AllowedSchemes: []string{"https"}, AllowedHosts: []string{"github.com"}}
+
+	if _, err := checkRepo(context.Background(), policy, "https://github.com/org/repo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkRepo(context.Background(), policy, "https://gitlab.com/org/repo"); err == nil {
+		t.Fatal("host outside allowed_hosts was accepted")
+	}
+}
+
+func TestRepoTargetPinsResolvedAddress(t *testing.T) {
+	stubLookup(t, map[string]string{"github.com": "140.82.121.4"})
+	policy := &config.Repos{AllowedSchemes: []string{"https", "ssh"}}
+
+	target, err := checkRepo(context.Background(), policy, "https://github.com/org/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := target.gitConfig(policy)
+	for _, want := range []string{
+		"protocol.allow=never",
+		"protocol.https.allow=always",
+		"protocol.ssh.allow=always",
+		"http.curloptResolve=github.com:443:140.82.121.4",
+		"http.followRedirects=false",
+	} {
+		if !slices.Contains(args, want) {
+			t.Errorf("git config %v misses %s", args, want)
+		}
+	}
+}
+
+func TestRepoTargetPinsSSH(t *testing.T) {
+	stubLookup(t, map[string]string{"github.com": "140.82.121.4", "git.example.com": "2001:db8::1"})
+	policy := &config.Repos{AllowedSchemes: []string{"ssh"}}
+
+	for repo, want := range map[string]string{
+		"git@github.com:org/repo.git":             "GIT_SSH_COMMAND=ssh -o HostName=140.82.121.4 -o HostKeyAlias='github.com'",
+		"ssh://git@git.example.com:2222/repo.git": "GIT_SSH_COMMAND=ssh -o HostName=2001:db8::1 -o HostKeyAlias='[git.example.com]:2222'",
+	} {
+		target, err := checkRepo(context.Background(), policy, repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env := target.gitEnv(gitAuth{}); !slices.Contains(env, want) {
+			t.Errorf("%s: env = %v, want %s", repo, env, want)
+		}
+	}
+}
+
+func TestCheckRepoRejectsUnpinnableScheme(t *testing.T) {
+	stubLookup(t, map[string]string{"github.com": "140.82.121.4"})
+	policy := &config.Repos{AllowedSchemes: []string{"git"}}
+
+	if _, err := checkRepo(context.Background(), policy, "git://github.com/org/repo.git"); err == nil {
+		t.Fatal("git:// was allowed without pinning")
+	}
+	policy.AllowPrivateAddresses = true
+	if _, err := checkRepo(context.Background(), policy, "git://github.com/org/repo.git"); err != nil {
+		t.Fatalf("git:// with private addresses allowed: %v", err)
+	}
+}
+
+func TestStartSessionRejectsDisallowedRepo(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Repos = &config.Repos{AllowedSchemes: []string{"https"}}
+
+	_, err := StartNewSession(context.Background(), newFakeRuntime(), cfg, "token", Options{Repo: "file:///etc"})
+	if !errors.Is(err, SessionRequestIsInvalid) {
+		t.Fatalf("err = %v, want SessionRequestIsInvalid", err)
+	}
+}
@@ -0,0 +1,81 @@
+package sessions
+
+import (
+	"fmt"
+	"maps"
+	"nvimanywhere/internal/config"
+)
+
+// resolveResources overlays the per-request override on the configured
+// defaults. Limits left unset are capped at the admin maximum, so a
+// request can never end up with more than max_resources allows. A request
+// may only set the ulimits max_resources caps.
+func resolveResources(cfg *config.SessionRuntime, req *config.Resources) (config.Resources, error) {
+	res := *cfg.Resources
+	res.Ulimits = maps.Clone(cfg.Resources.Ulimits)
+
+	if req != nil {
+		if err := req.Validate("resources"); err != nil {
+			return config.Resources{}, fmt.Errorf("%w: %v", SessionRequestIsInvalid, err)
+		}
+		if req.CPUs > 0 {
+			res.CPUs = req.CPUs
+		}
+		if req.Memory != "" {
+			res.Memory = req.Memory
+			// A new memory limit invalidates an inherited swap total.
+			res.MemorySwap = req.MemorySwap
+		}
+		if req.MemorySwap != "" {
+			res.MemorySwap = req.MemorySwap
+		}
+		if req.PidsLimit > 0 {
+			res.PidsLimit = req.PidsLimit
+		}
+		if req.BlkioWeight > 0 {
+			res.BlkioWeight = req.BlkioWeight
+		}
+		for name, v := range req.Ulimits {
+			if _, ok := cfg.MaxResources.Ulimits[name]; !ok {
+				return config.Resources{}, fmt.Errorf("%w: ulimit %s has no maximum in max_resources", SessionRequestIsInvalid, name)
+			}
+			if res.Ulimits == nil {
+				res.Ulimits = make(map[string]int64)
+			}
+			res.Ulimits[name] = v
+		}
+	}
+
+	max := cfg.MaxResources
+	if res.CPUs == 0 {
+		res.CPUs = max.CPUs
+	}
+	if res.Memory == "" {
+		res.Memory = max.Memory
+	}
+	if res.MemorySwap == "" {
+		res.MemorySwap = max.MemorySwap
+	}
+	if res.PidsLimit == 0 {
+		res.PidsLimit = max.PidsLimit
+	}
+	if res.BlkioWeight == 0 {
+		res.BlkioWeight = max.BlkioWeight
+	}
+	for name, v := range max.Ulimits {
+		if _, ok := res.Ulimits[name]; !ok {
+			if res.Ulimits == nil {
+				res.Ulimits = make(map[string]int64)
+			}
+			res.Ulimits[name] = v
+		}
+	}
+
+	if err := res.Validate("resources"); err != nil {
+		return config.Resources{}, fmt.Errorf("%w: %v", SessionRequestIsInvalid, err)
+	}
+	if err := res.Within(max); err != nil {
+		return config.Resources{}, fmt.Errorf("%w: %v", SessionRequestIsInvalid, err)
+	}
+	return res, nil
+}
@@ -0,0 +1,71 @@
+package sessions
+
+import (
+	"errors"
+	"nvimanywhere/internal/config"
+	"testing"
+)
+
+func TestResolveResources(t *testing.T) {
+	cfg := &config.SessionRuntime{
+		Resources: &config.Resources{CPUs: 1, Memory: "1g", PidsLimit: 256},
+		MaxResources: &config.Resources{
+			CPUs:      4,
+			Memory:    "4g",
+			PidsLimit: 1024,
+			Ulimits:   map[string]int64{"nofile": 8192},
+		},
+	}
+
+	res, err := resolveResources(cfg, nil)
+	if err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	if res.CPUs != 1 || res.Memory != "1g" || res.PidsLimit != 256 || res.Ulimits["nofile"] != 8192 {
+		t.Fatalf("defaults resolved to %+v", res)
+	}
+
+	res, err = resolveResources(cfg, &config.Resources{CPUs: 2, Memory: "3g"})
+	if err != nil {
+		t.Fatalf("override: %v", err)
+	}
+	if res.CPUs != 2 || res.Memory != "3g" || res.PidsLimit != 256 {
+		t.Fatalf("override resolved to %+v", res)
+	}
+
+	overs := []*config.Resources{
+		{CPUs: 8},
+		{Memory: "8g"},
+		{PidsLimit: 4096},
+		{Ulimits: map[string]int64{"nofile": 65536}},
+		{Ulimits: map[string]int64{"nproc": 64}},
+		{Ulimits: map[string]int64{"nofiles": 64}},
+		{Memory: "not a size"},
+	}
+	for _, req := range overs {
+		if _, err := resolveResources(cfg, req); !errors.Is(err, SessionRequestIsInvalid) {
+			t.Errorf("resolveResources(%+v) = %v, want SessionRequestIsInvalid", req, err)
+		}
+	}
+}
+
+func TestContainerResources(t *testing.T) {
+	out := containerResources(config.Resources{
+		CPUs:      1.5,
+		Memory:    "512m",
+		PidsLimit: 100,
+		Ulimits:   map[string]int64{"nofile": 1024},
+	})
+	if out.NanoCPUs != 1_500_000_000 {
+		t.Errorf("NanoCPUs = %d", out.NanoCPUs)
+	}
+	if out.Memory != 512<<20 || out.MemorySwap != out.Memory {
+		t.Errorf("Memory = %d, MemorySwap = %d", out.Memory, out.MemorySwap)
+	}
+	if out.PidsLimit == nil || *out.PidsLimit != 100 {
+		t.Errorf("PidsLimit = %v", out.PidsLimit)
+	}
+	if len(out.Ulimits) != 1 || out.Ulimits[0].Name != "nofile" || out.Ulimits[0].Hard != 1024 {
+		t.Errorf("Ulimits = %v", out.Ulimits)
+	}
+}
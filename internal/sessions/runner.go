@@ -6,163 +6,86 @@ import (
 	"fmt"
 	"io"
 	"nvimanywhere/internal/config"
-	"sync"
-
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/mount"
-	"github.com/docker/docker/client"
-)
-
-var (
-	r    *runner
-	once sync.Once
+	"time"
 )
 
 var containerNotStarted = errors.New("Container is not started")
 
-type runner struct {
-	imageName  string
-	configPath string
-	cli        *client.Client
+// Runtime is the backend that hosts the editor process of a session.
+// Every runtime identifies its processes by an opaque id returned from Start.
+type Runtime interface {
+	// Start launches the editor with opts.Workspace as its working dir.
+	Start(ctx context.Context, opts StartOptions) (string, error)
+	// Attach returns the PTY output, the PTY input and a func that detaches.
+	Attach(ctx context.Context, id string) (io.Reader, io.Writer, func() error, error)
+	Resize(ctx context.Context, id string, cols, rows int) error
+	// Terminate stops the process and releases everything Start allocated.
+	Terminate(ctx context.Context, id string) error
+	Inspect(ctx context.Context, id string) (RuntimeInfo, error)
+	// Exec runs a one-off command to completion in the workspace, isolated
+	// the same way as the editor Start would launch with opts. It is how
+	// the workspace is populated, so the gateway host never runs git.
+	Exec(ctx context.Context, opts StartOptions, cmd ExecCommand) error
+	// List returns every process, running or not, labelled with this
+	// gateway's id. Runtimes whose processes die with the gateway return
+	// none.
+	List(ctx context.Context) ([]RuntimeInfo, error)
 }
 
-func initRunner(cfg *config.SessionRuntime) error {
-	var e error
-	once.Do(func() {
-		cli, err := client.NewClientWithOpts(
-			client.FromEnv,
-			client.WithAPIVersionNegotiation(),
-		)
-		e = err
-		r = &runner{imageName: cfg.ImageName, configPath: cfg.NvimConfigPath, cli: cli}
-	})
-	return e
+// StartOptions describe the process of one session.
+type StartOptions struct {
+	Workspace string
+	// Resources and Network are enforced by container runtimes only.
+	Resources config.Resources
+	Network   string
+	// Labels identify the session's processes; see Reconcile. The runtime
+	// adds LabelRole itself.
+	Labels map[string]string
 }
 
-func getRunner() *runner {
-	if r != nil {
-		return r
-	}
-	return nil
+// ExecCommand is a command for Runtime.Exec. Mounts are host files made
+// visible read-only at the same path, for secrets the command needs.
+// Network, when set, replaces the session's network mode for this command
+// only; see fetchNetwork.
+type ExecCommand struct {
+	Args    []string
+	Env     []string
+	Mounts  []string
+	Network string
+	Stdout  io.Writer
+	Stderr  io.Writer
 }
 
-func (runner *runner) buildContainerSpec(workspace string) (*container.Config, *container.HostConfig) {
-	env := []string{
-		"TERM=xterm-256color",
-		"COLORTERM=truecolor",
-		"NVIM_LOG_FILE=/workspace/tmp/nvim.log",
-		"NVIM_LOG_LEVEL=debug",
-	}
-	if runner.imageName == "" {
-		runner.imageName = "ghcr.io/neovim/neovim:v0.10.3"
-	}
-
-	cfg := &container.Config{
-		Image:        runner.imageName,
-		Tty:          true,
-		OpenStdin:    true,
-		AttachStdin:  true,
-		AttachStdout: true,
-		AttachStderr: true,
-		Env:          env,
-		WorkingDir:   "/workspace",
-	}
-
-	mounts := []mount.Mount{
-		{
-			Type:     mount.TypeBind,
-			Source:   workspace,
-			Target:   "/workspace",
-			ReadOnly: false,
-		},
-	}
-	if runner.configPath != "" {
-		mounts = append(mounts, mount.Mount{
-			Type:     mount.TypeBind,
-			Source:   runner.configPath,
-			Target:   "/home/nvim/.config/nvim",
-			ReadOnly: false,
-		})
-	}
-
-	hostCfg := &container.HostConfig{Mounts: mounts, LogConfig: container.LogConfig{Type: "none"}}
-	return cfg, hostCfg
-}
-
-func (runner *runner) start(ctx context.Context, workspace string) (string, error) {
-	cfg, hostCfg := runner.buildContainerSpec(workspace)
-
-	resp, err := runner.cli.ContainerCreate(ctx, cfg, hostCfg, nil, nil, "")
-	if err != nil {
-		return "", fmt.Errorf("Failed to create container: %v", err)
-	}
-
-	if err := runner.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		return "", err
-	}
-
-	return resp.ID, nil
+// RuntimeInfo is a runtime-independent snapshot of a session process.
+type RuntimeInfo struct {
+	ID        string
+	Running   bool
+	StartedAt time.Time
+	ExitCode  int
+	Labels    map[string]string
 }
 
-func (runner *runner) attach(
-	ctx context.Context, id string) (
-	io.Reader,
-	io.Writer,
-	func() error,
-	error) {
-	if id == "" {
-		return nil, nil, nil, containerNotStarted
-	}
-
-	att, err := runner.cli.ContainerAttach(ctx, id, container.AttachOptions{
-		Stream: true, Stdin: true, Stdout: true, Stderr: true, Logs: false,
-	})
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("Attach container: %v", err)
-	}
-	closeAttach := att.Conn.Close
-
-	r := att.Reader
-	w := att.Conn
-
-	return r, w, closeAttach, nil
-}
-
-func (r *runner) terminateRuntime(ctx context.Context, id string) error {
-	if id == "" {
-		return containerNotStarted
-	}
-
-	if err := r.cli.ContainerStop(ctx, id, container.StopOptions{}); err != nil {
-		return err
-	}
-
-	statusCh, errCh := r.cli.ContainerWait(
-		ctx,
-		id,
-		container.WaitConditionNotRunning,
-	)
-
-	select {
-	case <-statusCh:
-	case err := <-errCh:
+func newRuntime(cfg *config.SessionRuntime) (Runtime, error) {
+	switch cfg.Runtime {
+	case config.RuntimeDocker:
+		r, err := newDockerRuntime(cfg)
 		if err != nil {
-			return err
+			return nil, err
 		}
-	case <-ctx.Done():
-		return ctx.Err()
-	}
-
-	if err := r.cli.ContainerRemove(ctx, id, container.RemoveOptions{}); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (runner *runner) resizePTY(ctx context.Context, cols, rows int, id string) error {
-	if id == "" {
-		return containerNotStarted
+		return r, nil
+	case config.RuntimePodman:
+		r, err := newPodmanRuntime(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	case config.RuntimeLocal:
+		r, err := newLocalRuntime(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	default:
+		return nil, fmt.Errorf("unknown session runtime %q", cfg.Runtime)
 	}
-	return runner.cli.ContainerResize(ctx, id, container.ResizeOptions{Width: uint(cols), Height: uint(rows)})
 }
@@ -0,0 +1,61 @@
+package sessions
+
+import (
+	"bytes"
+	"sync"
+)
+
+// scrollback keeps the last size bytes of PTY output so a client that
+// attaches later sees the screen right away instead of a blank terminal.
+type scrollback struct {
+	mu      sync.Mutex
+	buf     []byte
+	next    int
+	wrapped bool
+}
+
+func newScrollback(size int) *scrollback {
+	return &scrollback{buf: make([]byte, size)}
+}
+
+func (b *scrollback) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	if n >= len(b.buf) {
+		copy(b.buf, p[n-len(b.buf):])
+		b.next = 0
+		b.wrapped = true
+		return n, nil
+	}
+	c := copy(b.buf[b.next:], p)
+	if c < n {
+		copy(b.buf, p[c:])
+		b.wrapped = true
+	}
+	b.next = (b.next + n) % len(b.buf)
+	if b.next == 0 && n > 0 {
+		b.wrapped = true
+	}
+	return n, nil
+}
+
+// Snapshot returns the buffered output in order. Once the buffer has
+// wrapped the oldest bytes may start mid escape sequence, so the snapshot
+// starts at the first ESC instead.
+func (b *scrollback) Snapshot() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.wrapped {
+		return append([]byte(nil), b.buf[:b.next]...)
+	}
+	out := make([]byte, 0, len(b.buf))
+	out = append(out, b.buf[b.next:]...)
+	out = append(out, b.buf[:b.next]...)
+	if i := bytes.IndexByte(out, 0x1b); i > 0 {
+		out = out[i:]
+	}
+	return out
+}
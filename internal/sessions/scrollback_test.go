@@ -0,0 +1,32 @@
+package sessions
+
+import "testing"
+
+func TestScrollback(t *testing.T) {
+	b := newScrollback(8)
+	if got := b.Snapshot(); len(got) != 0 {
+		t.Fatalf("empty snapshot = %q", got)
+	}
+
+	b.Write([]byte("abc"))
+	b.Write([]byte("def"))
+	if got := string(b.Snapshot()); got != "abcdef" {
+		t.Fatalf("snapshot = %q", got)
+	}
+
+	b.Write([]byte("gh\x1b[1m"))
+	if got := string(b.Snapshot()); got != "\x1b[1m" {
+		t.Fatalf("wrapped snapshot should start at ESC, got %q", got)
+	}
+
+	b.Write([]byte("0123456789\x1bXYZ"))
+	if got := string(b.Snapshot()); got != "\x1bXYZ" {
+		t.Fatalf("oversized write snapshot = %q", got)
+	}
+
+	b = newScrollback(4)
+	b.Write([]byte("wxyz"))
+	if got := string(b.Snapshot()); got != "wxyz" {
+		t.Fatalf("exactly full snapshot = %q", got)
+	}
+}
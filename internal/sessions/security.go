@@ -0,0 +1,90 @@
+package sessions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"nvimanywhere/internal/config"
+	"os"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// defaultTmpfs are the dirs nvim and its plugins write outside /workspace.
+var defaultTmpfs = []string{
+	"/tmp",
+	"/home/nvim/.local/state",
+	"/home/nvim/.cache",
+}
+
+// securityProfile is a config.Security resolved once at runtime init.
+type securityProfile struct {
+	cfg         *config.Security
+	seccompJSON string
+	tmpfsSize   int64
+}
+
+func loadSecurityProfile(sec *config.Security) (*securityProfile, error) {
+	p := &securityProfile{cfg: sec}
+	if sec == nil {
+		p.cfg = &config.Security{}
+		return p, nil
+	}
+
+	if sec.SeccompProfile != "" {
+		// The engine API takes the profile body, not a path.
+		b, err := os.ReadFile(sec.SeccompProfile)
+		if err != nil {
+			return nil, fmt.Errorf("Read seccomp profile: %w", err)
+		}
+		compact := &bytes.Buffer{}
+		if err := json.Compact(compact, b); err != nil {
+			return nil, fmt.Errorf("Parse seccomp profile %s: %w", sec.SeccompProfile, err)
+		}
+		p.seccompJSON = compact.String()
+	}
+
+	size, err := config.ParseSize(sec.TmpfsSize)
+	if err != nil {
+		return nil, err
+	}
+	p.tmpfsSize = size
+	return p, nil
+}
+
+func (p *securityProfile) apply(hostCfg *container.HostConfig) {
+	sec := p.cfg
+	hostCfg.CapDrop = sec.CapDrop
+	hostCfg.CapAdd = sec.CapAdd
+	hostCfg.Runtime = sec.OCIRuntime
+
+	if sec.NoNewPrivileges {
+		hostCfg.SecurityOpt = append(hostCfg.SecurityOpt, "no-new-privileges:true")
+	}
+	if p.seccompJSON != "" {
+		hostCfg.SecurityOpt = append(hostCfg.SecurityOpt, "seccomp="+p.seccompJSON)
+	}
+	if sec.AppArmorProfile != "" {
+		hostCfg.SecurityOpt = append(hostCfg.SecurityOpt, "apparmor="+sec.AppArmorProfile)
+	}
+
+	if !sec.ReadOnlyRootfs {
+		return
+	}
+	hostCfg.ReadonlyRootfs = true
+	dirs := sec.Tmpfs
+	if len(dirs) == 0 {
+		dirs = defaultTmpfs
+	}
+	for _, dir := range dirs {
+		hostCfg.Mounts = append(hostCfg.Mounts, mount.Mount{
+			Type:   mount.TypeTmpfs,
+			Target: dir,
+			TmpfsOptions: &mount.TmpfsOptions{
+				SizeBytes: p.tmpfsSize,
+				Mode:      0o1777,
+			},
+		})
+	}
+}
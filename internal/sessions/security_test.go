@@ -0,0 +1,66 @@
+package sessions
+
+import (
+	"nvimanywhere/internal/config"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestSecurityProfileApply(t *testing.T) {
+	seccomp := filepath.Join(t.TempDir(), "seccomp.json")
+	if err := os.WriteFile(seccomp, []byte("{\n  \"defaultAction\": \"SCMP_ACT_ERRNO\"\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadSecurityProfile(&config.Security{
+		CapDrop:         []string{"ALL"},
+		NoNewPrivileges: true,
+		ReadOnlyRootfs:  true,
+		TmpfsSize:       "64m",
+		SeccompProfile:  seccomp,
+		AppArmorProfile: "nvimanywhere",
+		OCIRuntime:      "runsc",
+	})
+	if err != nil {
+		t.Fatalf("loadSecurityProfile: %v", err)
+	}
+
+	hostCfg := &container.HostConfig{}
+	p.apply(hostCfg)
+
+	if !hostCfg.ReadonlyRootfs || hostCfg.Runtime != "runsc" || !slices.Equal(hostCfg.CapDrop, []string{"ALL"}) {
+		t.Fatalf("hostCfg = %+v", hostCfg)
+	}
+	wantOpts := []string{
+		"no-new-privileges:true",
+		`seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`,
+		"apparmor=nvimanywhere",
+	}
+	if !slices.Equal(hostCfg.SecurityOpt, wantOpts) {
+		t.Fatalf("SecurityOpt = %q, want %q", hostCfg.SecurityOpt, wantOpts)
+	}
+	if len(hostCfg.Mounts) != len(defaultTmpfs) {
+		t.Fatalf("got %d tmpfs mounts, want %d", len(hostCfg.Mounts), len(defaultTmpfs))
+	}
+	for i, m := range hostCfg.Mounts {
+		if m.Type != mount.TypeTmpfs || m.Target != defaultTmpfs[i] || m.TmpfsOptions.SizeBytes != 64<<20 {
+			t.Errorf("mount %d = %+v", i, m)
+		}
+	}
+}
+
+func TestSecurityProfileZeroValueKeepsDefaults(t *testing.T) {
+	p, err := loadSecurityProfile(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostCfg := &container.HostConfig{}
+	p.apply(hostCfg)
+	if hostCfg.ReadonlyRootfs || len(hostCfg.SecurityOpt) != 0 || len(hostCfg.Mounts) != 0 {
+		t.Fatalf("zero profile changed hostCfg: %+v", hostCfg)
+	}
+}
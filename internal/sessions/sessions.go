@@ -4,78 +4,206 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"nvimanywhere/internal/config"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"golang.org/x/sync/errgroup"
 )
 
-var initOnce sync.Once
-var initErr error
+// Init prepares base_path and returns the configured runtime, which
+// every session, the pool and Reconcile are then handed.
+func Init(cfg *config.Config) (Runtime, error) {
+	runtime, err := newRuntime(cfg.SessionRuntime)
+	if err != nil {
+		return nil, fmt.Errorf("init runtime: %w", err)
+	}
 
-func Init(cfg *config.Config) error {
-	initOnce.Do(func() {
-		if err := initRunner(cfg.SessionRuntime); err != nil {
-			initErr = fmt.Errorf("init runtime: %w", err)
-			return
-		}
+	if err := os.MkdirAll(cfg.SessionRuntime.BasePath, 0o755); err != nil {
+		return nil, fmt.Errorf(
+			"create workspaces dir %q: %w",
+			cfg.SessionRuntime.BasePath,
+			err,
+		)
+	}
+	return runtime, nil
+}
 
-		if err := os.MkdirAll(cfg.SessionRuntime.BasePath, 0o755); err != nil {
-			initErr = fmt.Errorf(
-				"create workspaces dir %q: %w",
-				cfg.SessionRuntime.BasePath,
-				err,
-			)
-			return
+func StartNewSession(parentCtx context.Context, runtime Runtime, cfg *config.SessionRuntime, workspaceEndpoint string, opts Options) (*Session, error) {
+	if runtime == nil {
+		return nil, fmt.Errorf("Session runtime is not initialized")
+	}
+	if opts.Archive != "" && opts.Repo != "" {
+		return nil, fmt.Errorf("%w: a session takes a repo or an archive, not both", SessionRequestIsInvalid)
+	}
+	res, err := resolveResources(cfg, opts.Resources)
+	if err != nil {
+		return nil, err
+	}
+	netMode, err := resolveNetworkMode(cfg.Network, opts.Network)
+	if err != nil {
+		return nil, err
+	}
+	clone, err := resolveClone(parentCtx, cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	rootPath := filepath.Join(cfg.BasePath, workspaceEndpoint)
+	reopened := false
+	if opts.Workspace != "" {
+		rootPath = opts.Workspace
+		entries, err := os.ReadDir(rootPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Failed to open workspace: %w", err)
 		}
-	})
+		reopened = len(entries) > 0
+	}
+	if reopened && (opts.Archive != "" || opts.Ref != "" || opts.Depth != 0 || len(opts.Sparse) > 0) {
+		return nil, fmt.Errorf("%w: the workspace already has files; ref, depth, sparse and archives only seed a new one", SessionRequestIsInvalid)
+	}
+	s := newSession(parentCtx, runtime, cfg, workspaceEndpoint, rootPath, time.Now())
+	s.repoUrl = opts.Repo
+	s.clone = clone
+	s.creds = opts.Credentials
+	s.archive = opts.Archive
+	s.persistent = opts.Workspace != ""
+	s.reopened = reopened
+	for k, v := range opts.Labels {
+		s.labels[k] = v
+	}
 
-	return initErr
+	if err := prepareWorkspaceDir(s.rootPath); err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.opts = StartOptions{
+		Workspace: s.rootPath,
+		Resources: res,
+		Network:   netMode,
+	}
+	// Commands run while populating carry the labels too, so a gateway
+	// that dies mid-clone can find their containers.
+	s.opts.Labels = s.runtimeLabels()
+	go s.boot(s.opts)
+
+	return s, nil
 }
 
-func StartNewSession(parentCtx context.Context, cfg *config.SessionRuntime, url, workspaceEndpoint string) (*Session, error) {
+// newSession returns a session in the creating state, with the fields
+// every session shares. token names the session; rootPath is its workspace.
+func newSession(parentCtx context.Context, runtime Runtime, cfg *config.SessionRuntime, token, rootPath string, now time.Time) *Session {
 	ctx, cancel := context.WithCancel(parentCtx)
-
 	s := &Session{
-		ctx:       ctx,
-		cancel:    cancel,
-		createdAt: time.Now(),
-		repoUrl:   url,
-		cfg:       cfg,
-		rootPath:  filepath.Join(cfg.BasePath, workspaceEndpoint),
+		ctx:        ctx,
+		cancel:     cancel,
+		token:      token,
+		createdAt:  now,
+		cfg:        cfg,
+		rootPath:   rootPath,
+		runtime:    runtime,
+		labels:     make(map[string]string),
+		scrollback: newScrollback(cfg.ScrollbackBytes),
+		booted:     make(chan struct{}),
+		state:      StateCreating,
+		stateSince: map[State]time.Time{StateCreating: now},
+		subs:       make(map[chan Status]struct{}),
+		updatedAt:  now,
+		// A session counts as detached from creation until the first attach.
+		detachedAt: now,
 	}
+	s.lastActive.Store(now.UnixNano())
+	return s
+}
 
-	if err := prepareWorkspaceDir(s.rootPath); err != nil {
-		cancel()
-		return nil, err
+// boot takes the session from creating to ready in the background, so
+// callers can follow the progress through Status.
+func (s *Session) boot(opts StartOptions) {
+	defer close(s.booted)
+
+	if !s.reopened {
+		if err := s.populateWorkspace(opts); err != nil {
+			s.fail(err)
+			return
+		}
 	}
-	if s.repoUrl != "" {
-		go s.cloneWorkspace()
+	s.populated = true
+	s.recordBaseCommit()
+	if err := s.transition(StateStarting); err != nil {
+		return
 	}
-	id, err := getRunner().start(ctx, s.rootPath)
+	opts.Labels = s.runtimeLabels()
+	id, err := s.runtime.Start(s.ctx, opts)
 	if err != nil {
-		cancel()
-		return nil, err
+		s.fail(fmt.Errorf("Failed to start runtime: %w", err))
+		return
 	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.runtimeId = id
+	s.setState(StateReady)
+}
 
-	return s, nil
+// populateWorkspace fills the workspace before the editor sees it: the repo
+// is cloned and post-clone hooks run to completion first, so nvim never
+// opens a half-written tree. Both run through the runtime, sandboxed like
+// the editor will be.
+func (s *Session) populateWorkspace(opts StartOptions) error {
+	if s.archive != "" {
+		return s.extractWorkspace()
+	}
+	if s.repoUrl == "" {
+		return nil
+	}
+	if err := s.transition(StateCloning); err != nil {
+		return err
+	}
+	if err := s.cloneWorkspace(opts); err != nil {
+		return err
+	}
+	return s.runPostCloneHooks(opts)
 }
 
+// Booted is closed once the session is ready or has failed to start.
+func (s *Session) Booted() <-chan struct{} {
+	return s.booted
+}
+
+// Close terminates the runtime and removes the workspace, unless it is a
+// named workspace that was populated. It is safe to call more than once;
+// later calls return the first result.
 func (s *Session) Close() error {
+	s.closeOnce.Do(func() {
+		s.transition(StateClosing)
+		s.cancel()
+		<-s.booted
+		s.closeErr = s.close()
+		s.transition(StateClosed)
+	})
+	return s.closeErr
+}
+
+func (s *Session) close() error {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	defer cancel()
 
-	if err := getRunner().terminateRuntime(ctx, s.runtimeId); err != nil {
-		return fmt.Errorf("Failed to terminate Runtime: %w", err)
+	// A session that failed before its runtime started has nothing to stop.
+	if s.runtimeId != "" {
+		if err := s.runtime.Terminate(ctx, s.runtimeId); err != nil {
+			return fmt.Errorf("Failed to terminate Runtime: %w", err)
+		}
+	}
+	// A named workspace whose first clone failed is emptied, so the next
+	// session seeds it again instead of opening a half-written tree.
+	if s.persistent && s.populated {
+		return nil
 	}
 	if err := os.RemoveAll(s.rootPath); err != nil {
 		return fmt.Errorf("Failed to remove workspace: %w", err)
@@ -83,11 +211,39 @@ func (s *Session) Close() error {
 	return nil
 }
 
+// Attach streams the session PTY over conn until the client goes away or
+// another client takes over. The session keeps running after Attach
+// returns, so the same token can be attached again.
 func (s *Session) Attach(conn *websocket.Conn) error {
 	conn.SetReadLimit(s.cfg.WS.MaxMessageSize)
+
+	select {
+	case <-s.booted:
+	case <-s.ctx.Done():
+		return SessionIsClosed
+	}
+	// Booting can outlast the read timeout, and nothing is read or
+	// pinged until it is over.
+	conn.SetReadDeadline(time.Now().Add(s.cfg.WS.ReadTimeout))
 	conn.SetPongHandler(func(string) error { conn.SetReadDeadline(time.Now().Add(s.cfg.WS.ReadTimeout)); return nil })
-	defer s.cancel()
-	output, input, closeAttach, err := getRunner().attach(s.ctx, s.runtimeId)
+
+	actx, release, err := s.takeOver()
+	if err != nil {
+		return err
+	}
+	defer release()
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if s.conn == conn {
+			s.conn = nil
+		}
+		s.mu.Unlock()
+	}()
+
+	output, input, closeAttach, err := s.runtime.Attach(actx, s.runtimeId)
 
 	if err != nil {
 		return err
@@ -95,16 +251,93 @@ func (s *Session) Attach(conn *websocket.Conn) error {
 
 	defer closeAttach()
 
-	grp, gctx := errgroup.WithContext(s.ctx)
+	replayed, err := s.replay(conn)
+	if err != nil {
+		return err
+	}
+
+	grp, gctx := errgroup.WithContext(actx)
 
-	grp.Go(func() error { return s.pumpInput(gctx, conn, input) })
+	grp.Go(func() error { return s.pumpInput(gctx, conn, input, replayed || s.repaint) })
 	grp.Go(func() error { return s.pumpOutput(gctx, conn, output) })
 	grp.Go(func() error { return s.pingConn(gctx, conn) })
+	// Blocked reads on either side don't observe gctx, so unblock them
+	// once any pump gives up.
+	grp.Go(func() error {
+		<-gctx.Done()
+		closeAttach()
+		conn.SetReadDeadline(time.Now())
+		return nil
+	})
 
-	if err := grp.Wait(); err != nil {
-		return err
+	err = grp.Wait()
+	if errors.Is(context.Cause(actx), SessionIsTakenOver) {
+		return SessionIsTakenOver
 	}
-	return nil
+	return err
+}
+
+// takeOver detaches the current client, if any, and waits until it has let
+// go of the runtime. release marks the session detached again.
+func (s *Session) takeOver() (context.Context, func(), error) {
+	s.mu.Lock()
+	if err := stateErr(s.state); err != nil {
+		s.mu.Unlock()
+		return nil, nil, err
+	}
+	if s.state != StateAttached {
+		s.setState(StateAttached)
+	}
+	if s.detach != nil {
+		s.detach(SessionIsTakenOver)
+	}
+	actx, cancel := context.WithCancelCause(s.ctx)
+	done := make(chan struct{})
+	prev := s.attachDone
+	s.detach = cancel
+	s.attachDone = done
+	s.mu.Unlock()
+
+	if prev != nil {
+		<-prev
+	}
+
+	release := func() {
+		s.mu.Lock()
+		if s.attachDone == done {
+			s.detach = nil
+			s.attachDone = nil
+			s.detachedAt = time.Now()
+			if s.state == StateAttached {
+				s.setState(StateDetached)
+			}
+		}
+		s.mu.Unlock()
+		cancel(nil)
+		close(done)
+	}
+	return actx, release, nil
+}
+
+// DetachedFor returns how long the session has had no client, 0 while one is
+// attached.
+func (s *Session) DetachedFor() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attachDone != nil {
+		return 0
+	}
+	return time.Since(s.detachedAt)
+}
+
+// Exited reports whether the editor process has stopped on its own.
+func (s *Session) Exited() bool {
+	<-s.booted
+	if s.runtimeId == "" {
+		return true
+	}
+	info, err := s.runtime.Inspect(s.ctx, s.runtimeId)
+	return err != nil || !info.Running
 }
 
 func (s *Session) pingConn(ctx context.Context, ws *websocket.Conn) error {
@@ -115,9 +348,9 @@ func (s *Session) pingConn(ctx context.Context, ws *websocket.Conn) error {
 		case <-ctx.Done():
 			return nil
 		case <-ticker.C:
+			// A failed ping ends this attach only: the session stays up
+			// and detached for the client to come back to.
 			if err := ws.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(s.cfg.WS.WriteTimeout)); err != nil {
-
-				s.cancel()
 				return err
 			}
 		}
@@ -125,7 +358,27 @@ func (s *Session) pingConn(ctx context.Context, ws *websocket.Conn) error {
 
 }
 
-func (s *Session) pumpInput(ctx context.Context, conn *websocket.Conn, input io.Writer) error {
+// replay sends the scrollback to a newly attached client and reports
+// whether there was anything to send.
+func (s *Session) replay(conn *websocket.Conn) (bool, error) {
+	snap := s.scrollback.Snapshot()
+	if len(snap) == 0 {
+		return false, nil
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(s.cfg.WS.WriteTimeout))
+	if err := conn.WriteMessage(websocket.BinaryMessage, snap); err != nil {
+		return false, fmt.Errorf("Failed to replay scrollback: %w", err)
+	}
+	return true, nil
+}
+
+// pumpInput forwards client input to the PTY. With redraw set, the first
+// resize is applied twice with different heights so the editor gets a
+// SIGWINCH and repaints over the replayed scrollback even when the
+// size did not change.
+func (s *Session) pumpInput(ctx context.Context, conn *websocket.Conn, input io.Writer, redraw bool) error {
 	for {
 		if err := ctx.Err(); err != nil {
 			return nil
@@ -137,6 +390,7 @@ func (s *Session) pumpInput(ctx context.Context, conn *websocket.Conn, input io.
 
 		switch t {
 		case websocket.BinaryMessage:
+			s.touch()
 			if _, err := input.Write(message); err != nil {
 				return fmt.Errorf("Failed to write data to terminal input chan: %w", err)
 			}
@@ -147,6 +401,12 @@ func (s *Session) pumpInput(ctx context.Context, conn *websocket.Conn, input io.
 				Rows int `json:"rows"`
 			}
 			if err := json.Unmarshal(message, &m); err == nil && m.Cols > 0 && m.Rows > 0 {
+				if redraw {
+					redraw = false
+					if err := s.resizePTY(ctx, m.Cols, m.Rows+1); err != nil {
+						return fmt.Errorf("Failed to resize terminal : %w", err)
+					}
+				}
 				if err := s.resizePTY(ctx, m.Cols, m.Rows); err != nil {
 					return fmt.Errorf("Failed to resize terminal : %w", err)
 				}
@@ -167,8 +427,13 @@ func (s *Session) pumpOutput(ctx context.Context, conn *websocket.Conn, output i
 			return fmt.Errorf("Failed to read data from terminal output chan: %w", err)
 		}
 		if n > 0 {
+			s.touch()
+			s.scrollback.Write(buf[:n])
+			s.writeMu.Lock()
 			conn.SetWriteDeadline(time.Now().Add(s.cfg.WS.WriteTimeout))
-			if err := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+			err := conn.WriteMessage(websocket.BinaryMessage, buf[:n])
+			s.writeMu.Unlock()
+			if err != nil {
 				return fmt.Errorf("Failed to write data to WS Conn: %w", err)
 			}
 		}
@@ -176,42 +441,82 @@ func (s *Session) pumpOutput(ctx context.Context, conn *websocket.Conn, output i
 }
 
 func (s *Session) resizePTY(ctx context.Context, cols, rows int) error {
-	return getRunner().resizePTY(ctx, cols, rows, s.runtimeId)
+	return s.runtime.Resize(ctx, s.runtimeId, cols, rows)
 }
 
-func (s *Session) cloneWorkspace() {
+func (s *Session) cloneWorkspace(opts StartOptions) error {
 	if s.repoUrl == "" || s.rootPath == "" {
-		s.fail(fmt.Errorf("Params are invalid, url:%s path:%s", s.repoUrl, s.rootPath))
-		return
+		return fmt.Errorf("Params are invalid, url:%s path:%s", s.repoUrl, s.rootPath)
 	}
-	args := []string{
-		"clone",
-		"--depth=1",
-		"--filter=blob:none",
-		"--single-branch",
-		"--no-tags",
-		s.repoUrl,
-		s.rootPath,
+	auth := gitAuth{cleanup: func() {}}
+	if s.creds != nil {
+		var err error
+		if auth, err = s.creds.gitAuth(s.repoUrl, s.cfg.SSHKnownHosts, s.cfg.BasePath); err != nil {
+			return err
+		}
+	}
+	defer auth.cleanup()
+
+	for _, args := range s.clone.commands(s.repoUrl) {
+		gitArgs := append([]string{"git"}, s.clone.target.gitConfig(s.cfg.Repos)...)
+		gitArgs = append(gitArgs, auth.config...)
+		stderr := &progressWriter{report: s.setProgress}
+		err := s.runtime.Exec(s.ctx, opts, ExecCommand{
+			Args:    append(gitArgs, args...),
+			Env:     s.clone.target.gitEnv(auth),
+			Mounts:  auth.mounts,
+			Network: fetchNetwork(opts.Network),
+			Stderr:  stderr,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed fetching repo: git %s: %v, %s", args[0], err, auth.redact(stderr.String()))
+		}
 	}
 
-	cmd := exec.CommandContext(s.ctx, "git", args...)
-	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
-	stderr := &bytes.Buffer{}
-	cmd.Stderr = stderr
+	if entities, err := os.ReadDir(s.rootPath); err != nil || len(entities) == 0 {
+		if err != nil {
+			return fmt.Errorf("Failed to check workspace: %v", err)
+		}
+		return fmt.Errorf("Workspace is empty")
+	}
+	return nil
+}
 
-	if err := cmd.Run(); err != nil {
-		s.fail(fmt.Errorf("Failded fetching repo: %v, %s", err, stderr.String()))
-		return
+// extractWorkspace unpacks the uploaded archive. Extraction is plain file
+// I/O with every entry checked, so unlike the clone it runs in the gateway.
+func (s *Session) extractWorkspace() error {
+	defer os.Remove(s.archive)
+	if err := s.transition(StateExtracting); err != nil {
+		return err
+	}
+	err := extractArchive(s.archive, s.rootPath, archiveLimits{
+		maxBytes: s.cfg.Uploads.ExtractedBytes(),
+		maxFiles: s.cfg.Uploads.MaxFiles,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to extract archive: %w", err)
 	}
+	return nil
+}
 
-	if entities, err := os.ReadDir(s.rootPath); err != nil || len(entities) == 0 {
+// runPostCloneHooks runs session_runtime.post_clone_hooks in order inside
+// the freshly cloned workspace. The first failing hook fails the session.
+func (s *Session) runPostCloneHooks(opts StartOptions) error {
+	for _, hook := range s.cfg.PostCloneHooks {
+		ctx, cancel := context.WithTimeout(s.ctx, s.cfg.HookTimeout)
+		output := &bytes.Buffer{}
+		err := s.runtime.Exec(ctx, opts, ExecCommand{
+			Args:   hook,
+			Env:    []string{"GIT_TERMINAL_PROMPT=0", allowProtocol(s.cfg.Repos)},
+			Stdout: output,
+			Stderr: output,
+		})
+		cancel()
 		if err != nil {
-			s.fail(fmt.Errorf("Failed to check workspace: %v", err))
-			return
+			return fmt.Errorf("Post-clone hook %q failed: %v, %s", strings.Join(hook, " "), err, output.String())
 		}
-		s.fail(fmt.Errorf("Workspace is empty"))
-		return
 	}
+	return nil
 }
 
 func prepareWorkspaceDir(path string) error {
@@ -223,9 +528,3 @@ func prepareWorkspaceDir(path string) error {
 	}
 	return nil
 }
-
-func (s *Session) fail(err error) {
-	s.errOnce.Do(func() {
-		s.lastError = err
-	})
-}
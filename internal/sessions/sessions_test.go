@@ -0,0 +1,440 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"nvimanywhere/internal/config"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func testConfig(t *testing.T) *config.SessionRuntime {
+	t.Helper()
+	return &config.SessionRuntime{
+		Runtime:         "fake",
+		BasePath:        t.TempDir(),
+		ScrollbackBytes: 4096,
+		HookTimeout:     10 * time.Second,
+		CloneDepth:      1,
+		Resources:       &config.Resources{},
+		MaxResources:    &config.Resources{},
+		Network: &config.Network{
+			Mode:         config.NetworkBridge,
+			AllowedModes: []string{config.NetworkBridge},
+		},
+		Repos: &config.Repos{
+			AllowedSchemes:        []string{"https", "ssh", "file"},
+			AllowPrivateAddresses: true,
+		},
+		Uploads: &config.Uploads{
+			MaxArchiveSize:   "1MiB",
+			MaxExtractedSize: "1MiB",
+			MaxFiles:         100,
+		},
+		WS: &config.WS{
+			MaxMessageSize: 32 * 1024,
+			ReadTimeout:    2 * time.Second,
+			WriteTimeout:   time.Second,
+			PingInterval:   500 * time.Millisecond,
+		},
+	}
+}
+
+func startTestSession(t *testing.T, cfg *config.SessionRuntime) (*Session, *fakeRuntime) {
+	t.Helper()
+	rt := newFakeRuntime()
+	s, err := StartNewSession(context.Background(), rt, cfg, "token", Options{})
+	if err != nil {
+		t.Fatalf("startSession: %v", err)
+	}
+	<-s.Booted()
+	if st := s.State(); st != StateReady {
+		t.Fatalf("session state = %s, want %s", st, StateReady)
+	}
+	return s, rt
+}
+
+// attachTestSession serves s over a WebSocket and returns the client end and
+// a channel receiving Attach's result.
+func attachTestSession(t *testing.T, s *Session) (*websocket.Conn, <-chan error) {
+	t.Helper()
+	done := make(chan error, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		done <- s.Attach(conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, done
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func readBinary(t *testing.T, conn *websocket.Conn) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if typ == websocket.BinaryMessage {
+			return msg
+		}
+	}
+}
+
+func TestStartSessionPreparesWorkspace(t *testing.T) {
+	cfg := testConfig(t)
+	s, rt := startTestSession(t, cfg)
+
+	if s.runtimeId == "" {
+		t.Fatal("runtime id is empty")
+	}
+	if st, err := os.Stat(s.rootPath); err != nil || !st.IsDir() {
+		t.Fatalf("workspace %q was not created: %v", s.rootPath, err)
+	}
+	p, _ := rt.proc(s.runtimeId)
+	if p.workspace != s.rootPath {
+		t.Fatalf("runtime got workspace %q, want %q", p.workspace, s.rootPath)
+	}
+}
+
+func TestStartSessionRuntimeError(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.startErr = errors.New("boom")
+	s, err := StartNewSession(context.Background(), rt, testConfig(t), "token", Options{})
+	if err != nil {
+		t.Fatalf("startSession: %v", err)
+	}
+	<-s.Booted()
+
+	st := s.Status()
+	if st.State != StateFailed || !strings.Contains(st.Error, "boom") {
+		t.Fatalf("status = %+v, want failed with runtime error", st)
+	}
+	if _, ok := st.Since[StateStarting]; !ok {
+		t.Fatalf("status should record the starting state: %+v", st.Since)
+	}
+}
+
+func TestStartSessionWithoutRuntime(t *testing.T) {
+	if _, err := StartNewSession(context.Background(), nil, testConfig(t), "token", Options{}); err == nil {
+		t.Fatal("expected error for nil runtime")
+	}
+}
+
+func TestAttachEchoesInput(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	conn, _ := attachTestSession(t, s)
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte("ihello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readBinary(t, conn)); got != "ihello" {
+		t.Fatalf("echo = %q, want %q", got, "ihello")
+	}
+}
+
+func TestAttachPumpsOutput(t *testing.T) {
+	s, rt := startTestSession(t, testConfig(t))
+	conn, _ := attachTestSession(t, s)
+	p, _ := rt.proc(s.runtimeId)
+	waitFor(t, "attach", func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.attaches == 1
+	})
+
+	if err := p.emit([]byte("\x1b[2Jready")); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readBinary(t, conn)); got != "\x1b[2Jready" {
+		t.Fatalf("output = %q", got)
+	}
+}
+
+func TestPumpInputResize(t *testing.T) {
+	s, rt := startTestSession(t, testConfig(t))
+	conn, _ := attachTestSession(t, s)
+	p, _ := rt.proc(s.runtimeId)
+
+	msgs := []string{
+		`{"type":"resize","cols":120,"rows":40}`,
+		`not json`,
+		`{"type":"resize","cols":0,"rows":40}`,
+		`{"type":"disconnect"}`,
+		`{"cols":80,"rows":24}`,
+	}
+	for _, m := range msgs {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "resizes", func() bool { return len(p.resizes()) == 2 })
+	got := p.resizes()
+	if got[0] != [2]int{120, 40} || got[1] != [2]int{80, 24} {
+		t.Fatalf("resizes = %v", got)
+	}
+}
+
+func TestAttachEndsWhenClientStopsAnsweringPings(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.WS.ReadTimeout = 300 * time.Millisecond
+	cfg.WS.PingInterval = 100 * time.Millisecond
+	s, _ := startTestSession(t, cfg)
+
+	// The client never reads, so pings are never answered with pongs.
+	_, done := attachTestSession(t, s)
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Attach returned nil, want read timeout error")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Attach did not return after read timeout")
+	}
+}
+
+func TestAttachKeepsAliveWhileClientAnswersPings(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.WS.ReadTimeout = 300 * time.Millisecond
+	cfg.WS.PingInterval = 100 * time.Millisecond
+	s, _ := startTestSession(t, cfg)
+	conn, done := attachTestSession(t, s)
+
+	// Reading makes the client answer pings automatically.
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Attach returned early: %v", err)
+	case <-time.After(time.Second):
+	}
+}
+
+func TestAttachOutlastsSlowBoot(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.WS.ReadTimeout = 300 * time.Millisecond
+	cfg.WS.PingInterval = 100 * time.Millisecond
+	cfg.PostCloneHooks = [][]string{{"sleep", "1"}}
+	s, err := StartNewSession(context.Background(), newFakeRuntime(), cfg, "token", Options{Repo: testRepo(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The client attaches while the hook still runs.
+	conn, _ := attachTestSession(t, s)
+	<-s.Booted()
+	waitFor(t, "attach", func() bool { return s.State() == StateAttached })
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte("ihello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readBinary(t, conn)); got != "ihello" {
+		t.Fatalf("echo = %q, want %q", got, "ihello")
+	}
+}
+
+func TestAttachEndsOnClientDisconnect(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	conn, done := attachTestSession(t, s)
+	conn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Attach did not return after client disconnect")
+	}
+}
+
+func TestCloseTerminatesRuntimeAndRemovesWorkspace(t *testing.T) {
+	s, rt := startTestSession(t, testConfig(t))
+	p, _ := rt.proc(s.runtimeId)
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !p.isTerminated() {
+		t.Fatal("runtime was not terminated")
+	}
+	if _, err := os.Stat(s.rootPath); !os.IsNotExist(err) {
+		t.Fatalf("workspace still exists: %v", err)
+	}
+}
+
+func TestCloseFailedSession(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.startErr = errors.New("boom")
+	s, err := StartNewSession(context.Background(), rt, testConfig(t), "token", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close of a session that never started = %v", err)
+	}
+	if s.State() != StateClosed {
+		t.Fatalf("state = %s, want closed", s.State())
+	}
+	if _, err := os.Stat(s.rootPath); !os.IsNotExist(err) {
+		t.Fatalf("workspace still exists: %v", err)
+	}
+}
+
+func TestAttachFailedSession(t *testing.T) {
+	rt := newFakeRuntime()
+	rt.startErr = errors.New("boom")
+	s, err := StartNewSession(context.Background(), rt, testConfig(t), "token", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, done := attachTestSession(t, s)
+	if err := <-done; !errors.Is(err, SessionIsFailed) {
+		t.Fatalf("Attach = %v, want SessionIsFailed", err)
+	}
+}
+
+func TestStateTransitions(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	conn, done := attachTestSession(t, s)
+	waitFor(t, "attached", func() bool { return s.State() == StateAttached })
+	conn.Close()
+	<-done
+	if s.State() != StateDetached {
+		t.Fatalf("state after disconnect = %s", s.State())
+	}
+	if err := s.transition(StateReady); err == nil {
+		t.Fatal("detached -> ready should be rejected")
+	}
+	s.Close()
+	st := s.Status()
+	for _, want := range []State{StateCreating, StateStarting, StateReady, StateAttached, StateDetached, StateClosing, StateClosed} {
+		if _, ok := st.Since[want]; !ok {
+			t.Errorf("status has no timestamp for %s", want)
+		}
+	}
+}
+
+func TestReattachAfterDisconnect(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	conn, done := attachTestSession(t, s)
+	conn.Close()
+	<-done
+
+	if s.DetachedFor() == 0 {
+		t.Fatal("session should be detached after disconnect")
+	}
+	if s.Exited() {
+		t.Fatal("runtime process should survive a disconnect")
+	}
+
+	conn, _ = attachTestSession(t, s)
+	waitFor(t, "reattach", func() bool { return s.DetachedFor() == 0 })
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readBinary(t, conn)); got != "again" {
+		t.Fatalf("echo after reattach = %q", got)
+	}
+}
+
+func TestAttachTakesOverPreviousClient(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	_, firstDone := attachTestSession(t, s)
+	waitFor(t, "first attach", func() bool { return s.DetachedFor() == 0 })
+
+	second, _ := attachTestSession(t, s)
+
+	select {
+	case err := <-firstDone:
+		if !errors.Is(err, SessionIsTakenOver) {
+			t.Fatalf("first Attach = %v, want SessionIsTakenOver", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("first client was not detached")
+	}
+
+	if err := second.WriteMessage(websocket.BinaryMessage, []byte("mine")); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readBinary(t, second)); got != "mine" {
+		t.Fatalf("echo on second client = %q", got)
+	}
+}
+
+func TestCloseIsIdempotent(t *testing.T) {
+	s, _ := startTestSession(t, testConfig(t))
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close = %v", err)
+	}
+	if !s.Exited() {
+		t.Fatal("closed session should report exited")
+	}
+}
+
+func TestReattachReplaysScrollbackAndRedraws(t *testing.T) {
+	s, rt := startTestSession(t, testConfig(t))
+	p, _ := rt.proc(s.runtimeId)
+
+	conn, done := attachTestSession(t, s)
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte("\x1b[2Jscreen")); err != nil {
+		t.Fatal(err)
+	}
+	readBinary(t, conn)
+	conn.Close()
+	<-done
+
+	conn, _ = attachTestSession(t, s)
+	if got := string(readBinary(t, conn)); got != "\x1b[2Jscreen" {
+		t.Fatalf("replay = %q", got)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"resize","cols":80,"rows":24}`)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "redraw resizes", func() bool { return len(p.resizes()) == 2 })
+	if got := p.resizes(); got[0] != [2]int{80, 25} || got[1] != [2]int{80, 24} {
+		t.Fatalf("resizes = %v, want a jiggle to force a redraw", got)
+	}
+}
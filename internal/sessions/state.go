@@ -0,0 +1,177 @@
+package sessions
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// State is a step in the session lifecycle.
+type State string
+
+const (
+	StateCreating State = "creating"
+	StateCloning  State = "cloning"
+	// StateExtracting is the counterpart of cloning for uploaded archives.
+	StateExtracting State = "extracting"
+	StateStarting   State = "starting"
+	StateReady      State = "ready"
+	StateAttached   State = "attached"
+	StateDetached   State = "detached"
+	StateFailed     State = "failed"
+	StateClosing    State = "closing"
+	StateClosed     State = "closed"
+)
+
+// transitions lists the states each state may move to. Any state but the
+// terminal ones can fail or start closing.
+var transitions = map[State][]State{
+	StateCreating:   {StateCloning, StateExtracting, StateStarting, StateFailed, StateClosing},
+	StateCloning:    {StateStarting, StateFailed, StateClosing},
+	StateExtracting: {StateStarting, StateFailed, StateClosing},
+	StateStarting:   {StateReady, StateFailed, StateClosing},
+	StateReady:      {StateAttached, StateFailed, StateClosing},
+	StateAttached:   {StateDetached, StateFailed, StateClosing},
+	StateDetached:   {StateAttached, StateFailed, StateClosing},
+	StateFailed:     {StateClosing},
+	StateClosing:    {StateClosed},
+	StateClosed:     {},
+}
+
+// Status is a point-in-time view of a session for the status API.
+type Status struct {
+	State State `json:"state"`
+	// Repo is the repository URL without credentials.
+	Repo      string    `json:"repo,omitempty"`
+	Ref       string    `json:"ref,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Since records when each state visited so far was entered.
+	Since    map[State]time.Time `json:"since"`
+	Progress *CloneProgress      `json:"progress,omitempty"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// setState moves the session to the next state. Callers hold s.mu.
+func (s *Session) setState(to State) error {
+	if !slices.Contains(transitions[s.state], to) {
+		return fmt.Errorf("Invalid session transition %s -> %s", s.state, to)
+	}
+	now := time.Now()
+	s.state = to
+	s.stateSince[to] = now
+	s.updatedAt = now
+	s.publishLocked()
+	return nil
+}
+
+func (s *Session) transition(to State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setState(to)
+}
+
+func (s *Session) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *Session) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statusLocked()
+}
+
+func (s *Session) statusLocked() Status {
+	st := Status{
+		State: s.state,
+		// Status is served to anyone with the session link.
+		Repo:      RedactRepo(s.repoUrl),
+		Ref:       s.clone.ref,
+		CreatedAt: s.createdAt,
+		UpdatedAt: s.updatedAt,
+		Since:     make(map[State]time.Time, len(s.stateSince)),
+	}
+	for k, v := range s.stateSince {
+		st.Since[k] = v
+	}
+	if s.progress != nil {
+		p := *s.progress
+		st.Progress = &p
+	}
+	if s.lastError != nil {
+		st.Error = s.lastError.Error()
+	}
+	return st
+}
+
+// Subscribe returns a channel that receives the session status after every
+// state or progress change. Slow readers only get the latest status.
+func (s *Session) Subscribe() (<-chan Status, func()) {
+	ch := make(chan Status, 1)
+	s.mu.Lock()
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+
+	unsubscribe := func() {
+		s.mu.Lock()
+		delete(s.subs, ch)
+		s.mu.Unlock()
+	}
+	return ch, unsubscribe
+}
+
+// publishLocked sends the current status to subscribers. Callers hold s.mu,
+// which also makes this the only sender, so dropping a stale pending
+// status never races with another send.
+func (s *Session) publishLocked() {
+	if len(s.subs) == 0 {
+		return
+	}
+	st := s.statusLocked()
+	for ch := range s.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- st
+	}
+}
+
+func (s *Session) setProgress(p CloneProgress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.progress != nil && *s.progress == p {
+		return
+	}
+	s.progress = &p
+	s.updatedAt = time.Now()
+	s.publishLocked()
+}
+
+// fail records the first error and marks the session failed.
+func (s *Session) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastError == nil {
+		s.lastError = err
+	}
+	if s.setState(StateFailed) != nil {
+		s.publishLocked()
+	}
+}
+
+// stateErr maps a state that can't be attached to its sentinel error.
+func stateErr(st State) error {
+	switch st {
+	case StateReady, StateAttached, StateDetached:
+		return nil
+	case StateFailed:
+		return SessionIsFailed
+	case StateClosing, StateClosed:
+		return SessionIsClosed
+	default:
+		return SessionIsNotReady
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"errors"
 	"nvimanywhere/internal/config"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 var (
@@ -13,18 +16,101 @@ var (
 	SessionIsNotReady = errors.New("Session is not in ready state")
 	SessionIsFailed   = errors.New("Session is failed")
 	SessionIsClosed   = errors.New("Session is closed")
+	// SessionIsTakenOver ends an attachment when another client attaches.
+	SessionIsTakenOver = errors.New("Session is attached by another client")
+
+	// SessionRequestIsInvalid wraps errors caused by the caller's options.
+	SessionRequestIsInvalid = errors.New("Session request is invalid")
+	// SessionIsNotARepo is returned for git exports of a plain workspace.
+	SessionIsNotARepo = errors.New("Session workspace is not a git repository")
+	// SessionHasNoChanges is returned for a push with nothing to push.
+	SessionHasNoChanges = errors.New("Session workspace has no changes")
+	// SessionPushIsRejected wraps a push the remote did not accept.
+	SessionPushIsRejected = errors.New("Push was rejected")
 )
 
+// Options are the caller-controlled parameters of a new session.
+type Options struct {
+	Repo string
+	// Ref is a branch, tag, full commit SHA or a ref such as
+	// refs/pull/123/head. The default branch is used when it is empty.
+	Ref string
+	// Depth overrides session_runtime.clone_depth; -1 clones the full
+	// history.
+	Depth int
+	// Sparse limits the checkout to these directories.
+	Sparse []string
+	// Credentials are used for the clone and for pushes from the session.
+	Credentials *Credentials
+	// Archive is the path of an uploaded .tar.gz, .tar or .zip that seeds
+	// the workspace instead of a clone. The session removes it once it has
+	// been extracted.
+	Archive string
+	// Resources override session_runtime.resources within max_resources.
+	Resources *config.Resources
+	// Network is one of session_runtime.network.allowed_modes.
+	Network string
+	// Workspace is the directory of a named workspace to work in instead
+	// of a fresh one under base_path. It is kept when the session closes,
+	// and a workspace that already has files is opened as is, without a
+	// clone or an archive.
+	Workspace string
+	// Labels are put on the session's containers next to the gateway's
+	// own. Keys under "nvimanywhere." are restored on the session when
+	// Reconcile adopts it.
+	Labels map[string]string
+}
+
 type Session struct {
 	ctx    context.Context
 	cancel context.CancelFunc
+	token  string
 
 	createdAt time.Time
 	repoUrl   string
+	clone     cloneSpec
+	// creds stay in the gateway for pushes; the editor never sees them.
+	creds     *Credentials
+	archive   string
 	cfg       *config.SessionRuntime
-	rootPath   string
+	rootPath  string
+	runtime   Runtime
 	runtimeId string
+	// opts is what the runtime starts the editor and runs commands with.
+	opts StartOptions
+	// baseCommit is HEAD right after the workspace was populated, the
+	// starting point of patch exports. Empty when it is not a git repo.
+	baseCommit string
+	pushMu     sync.Mutex
+	// persistent workspaces outlive the session once they are populated.
+	persistent bool
+	reopened   bool
+	populated  bool
+	// repaint makes the first attach redraw the editor, which drew its
+	// screen before anyone listened: adopted and pooled sessions.
+	repaint bool
+	labels  map[string]string
+
+	scrollback *scrollback
+	booted     chan struct{}
+	// lastActive is the UnixNano of the last terminal input or output.
+	lastActive atomic.Int64
+	// writeMu serializes data messages to the attached client.
+	writeMu sync.Mutex
+
+	mu         sync.Mutex
+	state      State
+	stateSince map[State]time.Time
+	updatedAt  time.Time
+	progress   *CloneProgress
+	subs       map[chan Status]struct{}
+	detach     context.CancelCauseFunc
+	attachDone chan struct{}
+	conn       *websocket.Conn
+	detachedAt time.Time
 
-	errOnce   sync.Once
 	lastError error
+
+	closeOnce sync.Once
+	closeErr  error
 }
@@ -0,0 +1,355 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"nvimanywhere/internal/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testRepo creates a local git repository. main has main.go and a
+// src/ and docs/ directory, the feature branch adds feature.go, and
+// refs/pull/7/head points at a commit that adds pr.go.
+func testRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"main.go":        "package main\n",
+		"src/lib.go":     "package src\n",
+		"docs/README.md": "docs\n",
+	} {
+		writeFile(t, filepath.Join(dir, name), data)
+	}
+	commit := []string{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "change"}
+	runGit(t, dir, "init", "-q", "-b", "main")
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, commit...)
+
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+	writeFile(t, filepath.Join(dir, "feature.go"), "package main\n")
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, commit...)
+
+	runGit(t, dir, "checkout", "-q", "--detach", "main")
+	writeFile(t, filepath.Join(dir, "pr.go"), "package main\n")
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, commit...)
+	runGit(t, dir, "update-ref", "refs/pull/7/head", "HEAD")
+	runGit(t, dir, "checkout", "-q", "main")
+	return "file://" + dir
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBootClonesAndRunsHooksBeforeStart(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.PostCloneHooks = [][]string{{"sh", "-c", "touch hooked"}}
+	rt := newFakeRuntime()
+
+	s, err := StartNewSession(context.Background(), rt, cfg, "token", Options{Repo: testRepo(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+
+	st := s.Status()
+	if st.State != StateReady {
+		t.Fatalf("status = %+v, want ready", st)
+	}
+	if !st.Since[StateCloning].Before(st.Since[StateStarting]) {
+		t.Fatalf("cloning should precede starting: %v", st.Since)
+	}
+	p, _ := rt.proc(s.runtimeId)
+	for _, want := range []string{"main.go", "hooked"} {
+		if !slices.Contains(p.seen, want) {
+			t.Errorf("editor started before %s existed; saw %v", want, p.seen)
+		}
+	}
+}
+
+func TestBootCloneFailureFailsSession(t *testing.T) {
+	rt := newFakeRuntime()
+	s, err := StartNewSession(context.Background(), rt, testConfig(t), "token",
+		Options{Repo: "file://" + filepath.Join(t.TempDir(), "missing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+
+	st := s.Status()
+	if st.State != StateFailed || !strings.Contains(st.Error, "Failed fetching repo") {
+		t.Fatalf("status = %+v, want failed clone", st)
+	}
+	if rt.started() != 0 {
+		t.Fatal("runtime must not start after a failed clone")
+	}
+}
+
+func TestBootHookFailureFailsSession(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.PostCloneHooks = [][]string{{"sh", "-c", "echo nope; exit 3"}}
+	rt := newFakeRuntime()
+
+	s, err := StartNewSession(context.Background(), rt, cfg, "token", Options{Repo: testRepo(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+
+	st := s.Status()
+	if st.State != StateFailed || !strings.Contains(st.Error, "nope") {
+		t.Fatalf("status = %+v, want failed hook", st)
+	}
+	if rt.started() != 0 {
+		t.Fatal("runtime must not start after a failed hook")
+	}
+}
+
+func TestBootClonesRequestedRef(t *testing.T) {
+	repo := testRepo(t)
+	prCommit := runGit(t, strings.TrimPrefix(repo, "file://"), "rev-parse", "refs/pull/7/head")
+
+	tests := map[string]struct {
+		ref  string
+		want string
+		not  string
+	}{
+		"branch":      {ref: "feature", want: "feature.go", not: "pr.go"},
+		"full branch": {ref: "refs/heads/feature", want: "feature.go", not: "pr.go"},
+		"pull ref":    {ref: "refs/pull/7/head", want: "pr.go", not: "feature.go"},
+		"commit":      {ref: prCommit, want: "pr.go", not: "feature.go"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := StartNewSession(context.Background(), newFakeRuntime(), testConfig(t), "token",
+				Options{Repo: repo, Ref: tt.ref})
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-s.Booted()
+
+			if st := s.Status(); st.State != StateReady || st.Ref != tt.ref {
+				t.Fatalf("status = %+v, want ready at %s", st, tt.ref)
+			}
+			if _, err := os.Stat(filepath.Join(s.rootPath, tt.want)); err != nil {
+				t.Errorf("%s missing: %v", tt.want, err)
+			}
+			if _, err := os.Stat(filepath.Join(s.rootPath, tt.not)); err == nil {
+				t.Errorf("%s should not be checked out", tt.not)
+			}
+		})
+	}
+}
+
+func TestBootSparseCheckout(t *testing.T) {
+	repo := testRepo(t)
+	for _, ref := range []string{"", "refs/pull/7/head"} {
+		s, err := StartNewSession(context.Background(), newFakeRuntime(), testConfig(t), "token",
+			Options{Repo: repo, Ref: ref, Sparse: []string{"src"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-s.Booted()
+
+		if st := s.Status(); st.State != StateReady {
+			t.Fatalf("ref %q: status = %+v, want ready", ref, st)
+		}
+		if _, err := os.Stat(filepath.Join(s.rootPath, "src", "lib.go")); err != nil {
+			t.Errorf("ref %q: sparse dir missing: %v", ref, err)
+		}
+		if _, err := os.Stat(filepath.Join(s.rootPath, "docs")); err == nil {
+			t.Errorf("ref %q: docs should be left out of the sparse checkout", ref)
+		}
+	}
+}
+
+func TestResolveCloneRejectsBadOptions(t *testing.T) {
+	cfg := testConfig(t)
+	for name, opts := range map[string]Options{
+		"ref without repo":   {Ref: "main"},
+		"option injection":   {Repo: "file:///x", Ref: "--upload-pack=sh"},
+		"dot dot":            {Repo: "file:///x", Ref: "main..evil"},
+		"space":              {Repo: "file:///x", Ref: "main branch"},
+		"depth below -1":     {Repo: "file:///x", Depth: -2},
+		"sparse escapes":     {Repo: "file:///x", Sparse: []string{"src/../../etc"}},
+		"sparse absolute":    {Repo: "file:///x", Sparse: []string{"/etc"}},
+		"sparse option-like": {Repo: "file:///x", Sparse: []string{"--stdin"}},
+	} {
+		if _, err := resolveClone(context.Background(), cfg, opts); !errors.Is(err, SessionRequestIsInvalid) {
+			t.Errorf("%s: err = %v, want SessionRequestIsInvalid", name, err)
+		}
+	}
+
+	spec, err := resolveClone(context.Background(), cfg, Options{Repo: "file:///x", Ref: "v1.2.0", Sparse: []string{"./src/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.depth != cfg.CloneDepth || !slices.Equal(spec.sparse, []string{"src"}) {
+		t.Fatalf("spec = %+v", spec)
+	}
+}
+
+func TestCloneCommandsOmitDepthForFullHistory(t *testing.T) {
+	for _, ref := range []string{"", "feature", strings.Repeat("a", 40)} {
+		for depth, want := range map[int]bool{-1: false, 5: true} {
+			spec := cloneSpec{ref: ref, depth: depth}
+			var got bool
+			for _, cmd := range spec.commands("file:///x") {
+				for _, arg := range cmd {
+					if strings.HasPrefix(arg, "--depth") || strings.HasPrefix(arg, "--shallow") {
+						got = true
+					}
+				}
+			}
+			if got != want {
+				t.Errorf("ref %q, depth %d: commands = %v", ref, depth, spec.commands("file:///x"))
+			}
+		}
+	}
+}
+
+func TestBootClonesFullHistory(t *testing.T) {
+	s, err := StartNewSession(context.Background(), newFakeRuntime(), testConfig(t), "token",
+		Options{Repo: testRepo(t), Ref: "feature", Depth: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+	defer s.Close()
+	if st := s.State(); st != StateReady {
+		t.Fatalf("state = %s, want ready", st)
+	}
+	if _, err := os.Stat(filepath.Join(s.rootPath, ".git", "shallow")); err == nil {
+		t.Fatal("clone is shallow, want the full history")
+	}
+	if n := runGit(t, s.rootPath, "rev-list", "--count", "HEAD"); n != "2" {
+		t.Fatalf("clone has %s commits, want 2", n)
+	}
+}
+
+func TestBootPopulatesThroughRuntime(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.PostCloneHooks = [][]string{{"sh", "-c", "true"}}
+	rt := newFakeRuntime()
+
+	s, err := StartNewSession(context.Background(), rt, cfg, "token", Options{Repo: testRepo(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+	if st := s.State(); st != StateReady {
+		t.Fatalf("state = %s, want ready", st)
+	}
+
+	execs := rt.executed()
+	if len(execs) != 3 {
+		t.Fatalf("runtime ran %d commands, want clone, hook and rev-parse", len(execs))
+	}
+	if clone := execs[0].Args; clone[0] != "git" || !slices.Contains(clone, "clone") {
+		t.Errorf("first command = %v, want git clone", clone)
+	}
+	if !slices.Contains(execs[1].Env, "GIT_ALLOW_PROTOCOL=https:ssh:file") {
+		t.Errorf("hook env = %v, want the protocol allowlist", execs[1].Env)
+	}
+}
+
+func TestOnlyGitLeavesSessionNetwork(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Network = &config.Network{Mode: config.NetworkNone, AllowedModes: []string{config.NetworkNone}}
+	cfg.PostCloneHooks = [][]string{{"sh", "-c", "true"}}
+	rt := newFakeRuntime()
+
+	s, err := StartNewSession(context.Background(), rt, cfg, "token", Options{Repo: testRepo(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+	if st := s.State(); st != StateReady {
+		t.Fatalf("state = %s, want ready", st)
+	}
+
+	execs := rt.executed()
+	if got := execs[0].Network; got != config.NetworkBridge {
+		t.Errorf("clone network = %q, want bridge", got)
+	}
+	if got := execs[1].Network; got != "" {
+		t.Errorf("hook network = %q, want the session's", got)
+	}
+}
+
+func TestNamedWorkspaceOutlivesSession(t *testing.T) {
+	repo := testRepo(t)
+	dir := filepath.Join(t.TempDir(), "named")
+	rt := newFakeRuntime()
+
+	s, err := StartNewSession(context.Background(), rt, testConfig(t), "token", Options{Repo: repo, Workspace: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+	writeFile(t, filepath.Join(dir, "notes.txt"), "remember\n")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Fatal("named workspace was removed on close")
+	}
+
+	// Reopening starts the editor on the files as they were left.
+	s, err = StartNewSession(context.Background(), rt, testConfig(t), "token2", Options{Repo: repo, Workspace: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+	defer s.Close()
+	if st := s.Status(); st.State != StateReady || !st.Since[StateCloning].IsZero() {
+		t.Fatalf("status = %+v, want ready without a clone", st)
+	}
+	if _, err := StartNewSession(context.Background(), rt, testConfig(t), "token3", Options{Ref: "feature", Repo: repo, Workspace: dir}); !errors.Is(err, SessionRequestIsInvalid) {
+		t.Fatalf("ref on a populated workspace: err = %v, want SessionRequestIsInvalid", err)
+	}
+}
+
+func TestNamedWorkspaceIsEmptiedWhenSeedingFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "named")
+	s, err := StartNewSession(context.Background(), newFakeRuntime(), testConfig(t), "token",
+		Options{Repo: "file:///nonexistent/repo.git", Workspace: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.Booted()
+	if st := s.State(); st != StateFailed {
+		t.Fatalf("state = %s, want failed", st)
+	}
+	s.Close()
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("half-seeded workspace was kept")
+	}
+}
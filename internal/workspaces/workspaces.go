@@ -0,0 +1,317 @@
+// Package workspaces keeps named workspaces that outlive their sessions.
+// Each owner has a directory of workspaces; a workspace is a directory
+// holding its metadata and the files the editor works on:
+//
+//	<root>/<owner>/<name>/workspace.json
+//	<root>/<owner>/<name>/files/
+//
+// Owner names come from the request and are encoded, so they never form
+// paths of their own.
+package workspaces
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	WorkspaceIsNotFound     = errors.New("Workspace is not found")
+	WorkspaceAlreadyExists  = errors.New("Workspace already exists")
+	WorkspaceIsInUse        = errors.New("Workspace is open in another session")
+	WorkspaceNameIsInvalid  = errors.New("Workspace name is invalid")
+	WorkspaceLimitIsReached = errors.New("Workspace limit is reached")
+)
+
+const (
+	metaFile = "workspace.json"
+	filesDir = "files"
+)
+
+// validName keeps names usable as a single path element and in URLs.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Workspace describes one named workspace.
+type Workspace struct {
+	Name string `json:"name"`
+	// Repo is what the workspace was first cloned from, if anything.
+	Repo       string    `json:"repo,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	InUse      bool      `json:"in_use"`
+	// Path is the directory the editor works in.
+	Path string `json:"-"`
+}
+
+// Store manages the workspaces below root. Only one session at a time may
+// have a workspace open; Open hands out a release func for that.
+type Store struct {
+	root        string
+	maxPerOwner int
+
+	mu    sync.Mutex
+	inUse map[string]bool
+}
+
+// New returns a store rooted at root. maxPerOwner of 0 means no limit.
+func New(root string, maxPerOwner int) *Store {
+	return &Store{root: root, maxPerOwner: maxPerOwner, inUse: make(map[string]bool)}
+}
+
+func ownerDir(owner string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(owner))
+}
+
+func (s *Store) dir(owner, name string) string {
+	return filepath.Join(s.root, ownerDir(owner), name)
+}
+
+func checkName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("%w: %q", WorkspaceNameIsInvalid, name)
+	}
+	return nil
+}
+
+// Open marks the workspace as in use and returns it, creating it first
+// when it does not exist. release must be called once the session using
+// it has closed.
+func (s *Store) Open(owner, name string) (ws Workspace, release func(), err error) {
+	if err := checkName(name); err != nil {
+		return Workspace{}, nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := s.dir(owner, name)
+	if s.inUse[dir] {
+		return Workspace{}, nil, WorkspaceIsInUse
+	}
+	ws, err = readMeta(dir)
+	if errors.Is(err, WorkspaceIsNotFound) {
+		ws, err = s.create(owner, name)
+	}
+	if err != nil {
+		return Workspace{}, nil, err
+	}
+	ws.LastUsedAt = time.Now()
+	if err := writeMeta(dir, ws); err != nil {
+		return Workspace{}, nil, err
+	}
+	if err := os.MkdirAll(ws.Path, 0o755); err != nil {
+		return Workspace{}, nil, fmt.Errorf("Failed to create workspace: %w", err)
+	}
+
+	s.inUse[dir] = true
+	ws.InUse = true
+	var once sync.Once
+	return ws, func() { once.Do(func() { s.release(dir) }) }, nil
+}
+
+func (s *Store) create(owner, name string) (Workspace, error) {
+	if s.maxPerOwner > 0 {
+		entries, err := os.ReadDir(filepath.Join(s.root, ownerDir(owner)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return Workspace{}, err
+		}
+		if len(entries) >= s.maxPerOwner {
+			return Workspace{}, fmt.Errorf("%w: at most %d workspaces are allowed", WorkspaceLimitIsReached, s.maxPerOwner)
+		}
+	}
+	dir := s.dir(owner, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Workspace{}, fmt.Errorf("Failed to create workspace: %w", err)
+	}
+	now := time.Now()
+	return Workspace{Name: name, CreatedAt: now, LastUsedAt: now, Path: filepath.Join(dir, filesDir)}, nil
+}
+
+// release records the end of a session as the workspace's last use, so
+// retention counts from when it was last worked on.
+func (s *Store) release(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inUse, dir)
+	if ws, err := readMeta(dir); err == nil {
+		ws.LastUsedAt = time.Now()
+		writeMeta(dir, ws)
+	}
+}
+
+// SetRepo records the repository a workspace was first cloned from.
+func (s *Store) SetRepo(owner, name, repo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir := s.dir(owner, name)
+	ws, err := readMeta(dir)
+	if err != nil {
+		return err
+	}
+	ws.Repo = repo
+	return writeMeta(dir, ws)
+}
+
+// List returns the owner's workspaces by name.
+func (s *Store) List(owner string) ([]Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(filepath.Join(s.root, ownerDir(owner)))
+	if errors.Is(err, os.ErrNotExist) {
+		return []Workspace{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Workspace, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() || checkName(e.Name()) != nil {
+			continue
+		}
+		dir := s.dir(owner, e.Name())
+		ws, err := readMeta(dir)
+		if err != nil {
+			continue
+		}
+		ws.InUse = s.inUse[dir]
+		list = append(list, ws)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Rename gives a workspace that is not in use a new name.
+func (s *Store) Rename(owner, name, newName string) (Workspace, error) {
+	if err := checkName(name); err != nil {
+		return Workspace{}, err
+	}
+	if err := checkName(newName); err != nil {
+		return Workspace{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, to := s.dir(owner, name), s.dir(owner, newName)
+	if s.inUse[from] {
+		return Workspace{}, WorkspaceIsInUse
+	}
+	ws, err := readMeta(from)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return Workspace{}, WorkspaceAlreadyExists
+	}
+	if err := os.Rename(from, to); err != nil {
+		return Workspace{}, fmt.Errorf("Failed to rename workspace: %w", err)
+	}
+	ws.Name = newName
+	ws.Path = filepath.Join(to, filesDir)
+	return ws, writeMeta(to, ws)
+}
+
+// Delete removes a workspace that is not in use, files and all.
+func (s *Store) Delete(owner, name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := s.dir(owner, name)
+	if s.inUse[dir] {
+		return WorkspaceIsInUse
+	}
+	if _, err := readMeta(dir); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("Failed to delete workspace: %w", err)
+	}
+	return nil
+}
+
+// Prune deletes every workspace, of any owner, that is not in use and was
+// last used before cutoff. It returns how many were deleted.
+func (s *Store) Prune(cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owners, err := os.ReadDir(s.root)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	var errs []error
+	for _, owner := range owners {
+		entries, err := os.ReadDir(filepath.Join(s.root, owner.Name()))
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			dir := filepath.Join(s.root, owner.Name(), e.Name())
+			ws, err := readMeta(dir)
+			if err != nil || s.inUse[dir] || !ws.LastUsedAt.Before(cutoff) {
+				continue
+			}
+			if err := os.RemoveAll(dir); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			pruned++
+		}
+	}
+	return pruned, errors.Join(errs...)
+}
+
+func readMeta(dir string) (Workspace, error) {
+	data, err := os.ReadFile(filepath.Join(dir, metaFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return Workspace{}, WorkspaceIsNotFound
+	}
+	if err != nil {
+		return Workspace{}, err
+	}
+	var ws Workspace
+	if err := json.Unmarshal(data, &ws); err != nil {
+		return Workspace{}, fmt.Errorf("Failed to read workspace metadata: %w", err)
+	}
+	ws.Name = filepath.Base(dir)
+	ws.Path = filepath.Join(dir, filesDir)
+	return ws, nil
+}
+
+// writeMeta replaces the metadata atomically, so a crash never leaves a
+// workspace that can't be read back.
+func writeMeta(dir string, ws Workspace) error {
+	data, err := json.Marshal(ws)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".workspace-*.json")
+	if err != nil {
+		return fmt.Errorf("Failed to write workspace metadata: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, metaFile))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Failed to write workspace metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package workspaces
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenCreatesAndReopens(t *testing.T) {
+	s := New(t.TempDir(), 0)
+
+	ws, release, err := s.Open("ada@example.com", "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ws.InUse || ws.Name != "dev" {
+		t.Fatalf("workspace = %+v", ws)
+	}
+	if err := os.WriteFile(filepath.Join(ws.Path, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Open("ada@example.com", "dev"); !errors.Is(err, WorkspaceIsInUse) {
+		t.Fatalf("second open: err = %v, want WorkspaceIsInUse", err)
+	}
+	release()
+	release()
+
+	again, release, err := s.Open("ada@example.com", "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if again.Path != ws.Path || !again.CreatedAt.Equal(ws.CreatedAt) {
+		t.Fatalf("reopened %+v, want %+v", again, ws)
+	}
+	if _, err := os.Stat(filepath.Join(again.Path, "main.go")); err != nil {
+		t.Fatal("files did not survive the session")
+	}
+}
+
+func TestOwnersAreSeparate(t *testing.T) {
+	s := New(t.TempDir(), 0)
+	_, release, err := s.Open("../ada", "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	if list, _ := s.List("bob"); len(list) != 0 {
+		t.Fatalf("bob sees %+v", list)
+	}
+	if err := s.Delete("bob", "dev"); !errors.Is(err, WorkspaceIsNotFound) {
+		t.Fatalf("bob deleting ada's workspace: err = %v, want WorkspaceIsNotFound", err)
+	}
+	if list, _ := s.List("../ada"); len(list) != 1 || list[0].Name != "dev" {
+		t.Fatalf("ada's workspaces = %+v", list)
+	}
+}
+
+func TestRenameAndDelete(t *testing.T) {
+	s := New(t.TempDir(), 0)
+	for _, name := range []string{"a", "b"} {
+		_, release, err := s.Open("ada", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	_, release, _ := s.Open("ada", "c")
+
+	if _, err := s.Rename("ada", "a", "b"); !errors.Is(err, WorkspaceAlreadyExists) {
+		t.Errorf("rename onto b: err = %v, want WorkspaceAlreadyExists", err)
+	}
+	if _, err := s.Rename("ada", "c", "d"); !errors.Is(err, WorkspaceIsInUse) {
+		t.Errorf("rename in use: err = %v, want WorkspaceIsInUse", err)
+	}
+	if err := s.Delete("ada", "c"); !errors.Is(err, WorkspaceIsInUse) {
+		t.Errorf("delete in use: err = %v, want WorkspaceIsInUse", err)
+	}
+	release()
+
+	ws, err := s.Rename("ada", "a", "renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.Name != "renamed" || filepath.Base(filepath.Dir(ws.Path)) != "renamed" {
+		t.Fatalf("renamed = %+v", ws)
+	}
+	if err := s.Delete("ada", "b"); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := s.List("ada")
+	if len(list) != 2 || list[0].Name != "c" || list[1].Name != "renamed" {
+		t.Fatalf("workspaces = %+v", list)
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	s := New(t.TempDir(), 0)
+	for _, name := range []string{"", ".", "..", ".hidden", "a/b", "-x", "a b"} {
+		if _, _, err := s.Open("ada", name); !errors.Is(err, WorkspaceNameIsInvalid) {
+			t.Errorf("%q: err = %v, want WorkspaceNameIsInvalid", name, err)
+		}
+	}
+}
+
+func TestMaxPerOwner(t *testing.T) {
+	s := New(t.TempDir(), 1)
+	_, release, err := s.Open("ada", "one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, _, err := s.Open("ada", "two"); !errors.Is(err, WorkspaceLimitIsReached) {
+		t.Fatalf("err = %v, want WorkspaceLimitIsReached", err)
+	}
+	if _, release, err := s.Open("ada", "one"); err != nil {
+		t.Fatalf("reopening an existing workspace: %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s := New(t.TempDir(), 0)
+	_, release, _ := s.Open("ada", "old")
+	release()
+	_, inUse, _ := s.Open("ada", "busy")
+	defer inUse()
+
+	n, err := s.Prune(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("pruned %d, want 1", n)
+	}
+	if list, _ := s.List("ada"); len(list) != 1 || list[0].Name != "busy" {
+		t.Fatalf("left %+v, want the workspace in use", list)
+	}
+
+	if n, _ := s.Prune(time.Now().Add(-time.Hour)); n != 0 {
+		t.Fatalf("pruned %d recently used workspaces", n)
+	}
+}
@@ -26,26 +26,80 @@ term.loadAddon(fitAddon);
 
 const container = document.getElementById('terminal');
 if (!container) throw new Error('Missing #terminal');
+const notice = document.getElementById('notice');
 
 term.open(container);
 term.focus();
 
 // ============================================================
 // WebSocket
+// ------------------------------------------------------------
+// The session outlives the socket: on a drop we reconnect to the
+// same token with backoff and resume the same nvim process.
 // ============================================================
 
 const proto = location.protocol === 'https:' ? 'wss' : 'ws';
-const ws = new WebSocket(`${proto}://${location.host}${location.pathname}`);
-ws.binaryType = 'arraybuffer';
-
+const wsUrl = `${proto}://${location.host}${location.pathname}`;
 const enc = new TextEncoder();
 
+const RECONNECT_DELAYS = [250, 500, 1000, 2000, 4000, 8000];
+const MAX_FAILED_ATTEMPTS = 10;
+
+let ws = null;
+let failedAttempts = 0;
+let reconnecting = false;
+// Set from a server control message when there is nothing to come
+// back to: 'exit' (nvim is gone) or 'replaced' (another window owns it).
+let finished = null;
+
+function connect() {
+  ws = new WebSocket(wsUrl);
+  ws.binaryType = 'arraybuffer';
+
+  ws.addEventListener('open', onOpen);
+  ws.addEventListener('message', onMessage);
+  ws.addEventListener('close', onClose);
+}
+
+function onOpen() {
+  failedAttempts = 0;
+  if (reconnecting) {
+    // The server replays its scrollback and nvim repaints on the resize
+    // below, so start from a clean screen.
+    term.reset();
+    reconnecting = false;
+  }
+  fitAndResize();
+}
+
+function onClose() {
+  if (finished === 'exit') {
+    window.location.href = '/';
+    return;
+  }
+  if (finished === 'replaced') {
+    return;
+  }
+  if (!reconnecting) {
+    term.write('\r\n\x1b[33m[connection lost, reconnecting…]\x1b[0m\r\n');
+  }
+  reconnecting = true;
+  failedAttempts++;
+  if (failedAttempts > MAX_FAILED_ATTEMPTS) {
+    window.location.href = '/';
+    return;
+  }
+  const delay = RECONNECT_DELAYS[Math.min(failedAttempts - 1, RECONNECT_DELAYS.length - 1)];
+  setTimeout(connect, delay);
+}
+
 // ============================================================
 // Initial fit + resize
 // ============================================================
 
 function sendResize(cols, rows) {
   if (
+    !ws ||
     ws.readyState !== WebSocket.OPEN ||
     cols <= 0 ||
     rows <= 0
@@ -67,14 +121,12 @@ if (document.fonts?.ready) {
   document.fonts.ready.then(fitAndResize);
 }
 
-ws.addEventListener('open', fitAndResize, { once: true });
-
 // ============================================================
 // Input → server
 // ============================================================
 
 term.onData((data) => {
-  if (ws.readyState === WebSocket.OPEN) {
+  if (ws && ws.readyState === WebSocket.OPEN) {
     ws.send(enc.encode(data));
   }
 });
@@ -83,29 +135,27 @@ term.onData((data) => {
 // Server → terminal (RAW BYTES ONLY)
 // ============================================================
 
-ws.addEventListener('message', (ev) => {
+function onMessage(ev) {
   if (typeof ev.data === 'string') {
     try {
       const m = JSON.parse(ev.data);
       if (m?.type === 'exit') {
-        term.write('\r\n\x1b[31m[disconnected]\x1b[0m\r\n');
+        finished = 'exit';
+        term.write(`\r\n\x1b[31m[${m.reason || 'disconnected'}]\x1b[0m\r\n`);
+      } else if (m?.type === 'warning') {
+        // An empty reason withdraws the warning after new activity.
+        notice.textContent = m.reason;
+        notice.hidden = !m.reason;
+      } else if (m?.type === 'replaced') {
+        finished = 'replaced';
+        term.write('\r\n\x1b[33m[session opened in another window]\x1b[0m\r\n');
       }
     } catch { }
     return;
   }
 
   term.write(new Uint8Array(ev.data));
-});
-
-// ============================================================
-// Needed for signal to server that tab is closing so it can close session
-// ============================================================
-window.addEventListener("beforeunload", () => {
-  if (ws.readyState === WebSocket.OPEN) {
-    ws.send(JSON.stringify({ type: "disconnect" }))
-  }
-})
-
+}
 
 // ============================================================
 // Resize handling
@@ -120,11 +170,4 @@ if ('ResizeObserver' in window) {
   ro.observe(container);
 }
 
-// ============================================================
-// Cleanup
-// ============================================================
-
-ws.addEventListener('close', () => {
-  window.location.href = '/';
-});
-
+connect();
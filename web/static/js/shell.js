@@ -7,7 +7,7 @@
  */
 
 const help = {
-  nvim: 'nvim  <Url>()     Creates new nvim session and upload repo by url if you provided it',
+  nvim: 'nvim [-w <name>] [-r <url>] [-b <ref>] [--depth <n>|full] [--sparse <dir>]... [--token <token>] | nvim [-w <name>] -u  Creates new nvim session, cloning the repo at a branch, tag, commit or PR ref if you provided it, or from an uploaded .zip/.tar.gz with -u. -w keeps the workspace under a name to reopen later',
   clear: 'clear            Clear shell screen',
   help: 'help [cmd]        Show help',
 };
@@ -77,7 +77,7 @@ async function handler() {
   const input = source.value.trim();
   if (!input) return;
 
-  await addLine(input, 'cmd', 200);
+  await addLine(maskSecrets(input), 'cmd', 200);
   clearCmdLine();
 
   const parsed = parseCmd(input);
@@ -96,7 +96,7 @@ function parseCmd(input) {
   return {
     name: parts[0],
     fn: commands[parts[0]],
-    args: parts[1] ?? null,
+    args: parts.slice(1),
   };
 }
 
@@ -116,7 +116,8 @@ function commitCmdLine(text) {
  * ============================================================
  */
 
-async function handleHelp(cmdName) {
+async function handleHelp(args) {
+  const cmdName = args[0];
   if (!cmdName) {
     for (let text of Object.values(help)) {
       await addLine(text, "color2", 500);
@@ -144,6 +145,11 @@ function step(text, waitFor) {
   return { text, waitFor };
 }
 
+function markLine(line, result) {
+  line.classList.remove("loading");
+  line.classList.add(result);
+}
+
 async function runSteps(steps) {
   let lastResult = null;
 
@@ -152,11 +158,9 @@ async function runSteps(steps) {
 
     try {
       lastResult = await step.waitFor();
-      line.classList.remove("loading");
-      line.classList.add("success");
+      markLine(line, "success");
     } catch (err) {
-      line.classList.remove("loading");
-      line.classList.add("error");
+      markLine(line, "error");
       throw err;
     }
   }
@@ -165,39 +169,179 @@ async function runSteps(steps) {
 }
 
 
+/* ============================================================
+ * Session Status Tracking
+ * ------------------------------------------------------------
+ * Follows the session's status events and prints one step per
+ * lifecycle state the server reports, until ready or failed.
+ * While cloning, the step line shows git's live progress.
+ * Falls back to polling GET /sessions/{token}/status.
+ * ============================================================
+ */
+
+const STATUS_POLL_MS = 300;
+
+const STATE_LABELS = {
+  creating: '  Creating session…',
+  cloning: '  Cloning repository…',
+  extracting: '  Unpacking archive…',
+  starting: '  Starting editor…',
+};
+
+function statusTracker() {
+  let state = null;
+  let line = null;
+  let queue = Promise.resolve();
+
+  // apply resolves with the status once it is ready, rejects when the
+  // session failed and resolves with null otherwise. Updates are chained
+  // so lines are printed in the order the server reported them.
+  function apply(status) {
+    queue = queue.then(async () => {
+      if (status.state === 'failed') {
+        if (line) markLine(line, "error");
+        throw new Error(status.error || "session failed");
+      }
+      if (status.state !== state) {
+        if (line) markLine(line, "success");
+        state = status.state;
+        line = STATE_LABELS[state]
+          ? await addLine(STATE_LABELS[state], "color2 loading", 0)
+          : null;
+      }
+      if (line && status.state === 'cloning' && status.progress) {
+        const { phase, percent } = status.progress;
+        setLineText(line, `${STATE_LABELS.cloning} ${phase} ${percent}%`);
+      }
+      return status.state === 'ready' ? status : null;
+    });
+    return queue;
+  }
+
+  return { apply };
+}
+
+async function fetchStatus(url) {
+  const res = await fetch('/' + url, { headers: { 'Accept': 'application/json' } });
+  if (!res.ok) throw new Error("status request failed");
+  return res.json();
+}
+
+async function pollStatus(url, tracker) {
+  for (;;) {
+    const ready = await tracker.apply(await fetchStatus(url));
+    if (ready) return ready;
+    await wait(STATUS_POLL_MS);
+  }
+}
+
+function streamStatus(url, tracker) {
+  return new Promise((resolve, reject) => {
+    const events = new EventSource('/' + url);
+    let ended = false;
+
+    function end(fn, value) {
+      ended = true;
+      events.close();
+      fn(value);
+    }
+
+    events.addEventListener('status', e => {
+      if (ended) return;
+      let status;
+      try {
+        status = JSON.parse(e.data);
+      } catch (err) {
+        end(reject, err);
+        return;
+      }
+      tracker.apply(status).then(ready => {
+        if (ready) end(resolve, ready);
+      }, err => end(reject, err));
+    });
+
+    // The server closes the stream once startup is over; EventSource
+    // would reconnect, so stop and let the caller fall back to polling.
+    events.onerror = () => {
+      if (!ended) end(resolve, null);
+    };
+  });
+}
+
+async function followStatus(data) {
+  const tracker = statusTracker();
+
+  if (window.EventSource && data.events) {
+    const ready = await streamStatus(data.events, tracker);
+    if (ready) return ready;
+  }
+  return pollStatus(data.status, tracker);
+}
+
+
 /* ============================================================
  * Nvim Command (Domain Logic)
  * ------------------------------------------------------------
- * Orchestrates request startup and step execution.
- * Uses the generic step runner without special cases.
+ * Creates the session, then follows its real startup progress.
  * ============================================================
  */
 
-async function handleNvim() {
-  const body = getBody();
+async function handleNvim(args) {
+  let body;
+  try {
+    body = getBody(args);
+  } catch (err) {
+    await addLine(`  ${err.message}`, "color2 error", 0);
+    return;
+  }
+  let request;
+  if (body.upload) {
+    const archive = await pickArchive();
+    if (!archive) {
+      await addLine("  No archive selected", "color2 error", 0);
+      return;
+    }
+    const form = new FormData();
+    form.append('options', JSON.stringify(body.workspace ? { workspace: body.workspace } : {}));
+    form.append('archive', archive);
+    request = { label: `Uploading ${archive.name}…`, init: { body: form } };
+  } else {
+    request = {
+      label: 'Starting session…',
+      init: { headers: { 'Content-Type': 'application/json' }, body: JSON.stringify(body) },
+    };
+  }
+
   const requestPromise = fetch('/sessions/new', {
     method: 'POST',
-    headers: {
-      'Content-Type': 'application/json',
-      'Accept': 'application/json'
-    },
-    body: JSON.stringify(body)
-  }).then(res => {
-    if (!res.ok) throw new Error("request failed");
+    ...request.init,
+    headers: { ...request.init.headers, 'Accept': 'application/json' },
+  }).then(async res => {
+    if (!res.ok) throw new Error((await res.text()).trim() || "request failed");
     return res.json();
   });
 
-  const steps = [
-    step('Starting session…', () => wait(700)),
-    step('  Creating session…', () => wait(700)),
-    step('  Preparing workspace…', () => wait(700)),
-    step('  Preparing environment…', () => wait(700)),
-    step('  Starting editor…', () => requestPromise),
-  ];
+  try {
+    const data = await runSteps([step(request.label, () => requestPromise)]);
+    await followStatus(data);
+    window.location = window.location + data.endpoint;
+  } catch (err) {
+    await addLine(`  ${err.message}`, "color2 error", 0);
+  }
+}
 
-  const data = await runSteps(steps);
 
-  window.location = window.location + data.endpoint;
+// pickArchive opens the browser's file picker and resolves with the chosen
+// file, or null when the picker is dismissed.
+function pickArchive() {
+  return new Promise(resolve => {
+    const input = document.createElement('input');
+    input.type = 'file';
+    input.accept = '.zip,.tar,.tar.gz,.tgz';
+    input.addEventListener('change', () => resolve(input.files[0] ?? null));
+    input.addEventListener('cancel', () => resolve(null));
+    input.click();
+  });
 }
 
 
@@ -220,14 +364,55 @@ function writing() {
  * ============================================================
  */
 
-function getUrl() {
-  const cmd = source.value;
-  const parts = cmd.trim().split(/\s+/);
-  const i = parts.indexOf("-r");
-  if (i === -1 || i === parts.length - 1) return null;
+const NVIM_FLAGS = {
+  '-r': 'repo',
+  '-w': 'workspace',
+  '--workspace': 'workspace',
+  '-b': 'ref',
+  '--depth': 'depth',
+  '--sparse': 'sparse',
+  '--user': 'username',
+  '--token': 'token',
+};
+
+// SWITCH_FLAGS take no value.
+const SWITCH_FLAGS = {
+  '-u': 'upload',
+  '--upload': 'upload',
+};
+
+// SECRET_FLAGS take values that must not be echoed into the history.
+const SECRET_FLAGS = ['--token'];
 
-  const candidate = parts[i + 1];
-  return (candidate) ? candidate : null;
+function maskSecrets(input) {
+  const parts = input.split(/(\s+)/);
+  for (let i = 0; i < parts.length; i++) {
+    if (SECRET_FLAGS.includes(parts[i]) && parts[i + 2]) parts[i + 2] = '********';
+  }
+  return parts.join('');
+}
+
+// parseNvimArgs reads `-r <url> -b <ref> --depth <n|full> --sparse <dir>`;
+// --sparse may be repeated.
+function parseNvimArgs(args) {
+  const opts = { sparse: [] };
+
+  for (let i = 0; i < args.length; i++) {
+    if (SWITCH_FLAGS[args[i]]) {
+      opts[SWITCH_FLAGS[args[i]]] = true;
+      continue;
+    }
+    const key = NVIM_FLAGS[args[i]];
+    if (!key) throw new Error(`Unknown option ${args[i]}`);
+
+    const value = args[i + 1];
+    if (value === undefined) throw new Error(`Option ${args[i]} needs a value`);
+    i++;
+
+    if (key === 'sparse') opts.sparse.push(value);
+    else opts[key] = value;
+  }
+  return opts;
 }
 
 function isValidUrl(s) {
@@ -239,9 +424,34 @@ function isValidUrl(s) {
   }
 }
 
-function getBody() {
-  const url = getUrl();
-  return url ? { Repo: url } : {};
+function getBody(args) {
+  const opts = parseNvimArgs(args);
+  const named = opts.workspace ? { workspace: opts.workspace } : {};
+  if (opts.upload) {
+    if (opts.repo) throw new Error("-u uploads an archive instead of cloning -r <url>");
+    return { ...named, upload: true };
+  }
+  if (!opts.repo) {
+    if (opts.ref || opts.depth || opts.sparse.length) throw new Error("-b, --depth and --sparse need -r <url>");
+    // A named workspace remembers its repo, so a token alone is enough to push.
+    if (opts.token && !opts.workspace) throw new Error("--token needs -r <url> or -w <name>");
+    if (opts.token) named.credentials = { username: opts.username, token: opts.token };
+    return named;
+  }
+
+  const body = { ...named, repo: opts.repo };
+  if (opts.ref) body.ref = opts.ref;
+  if (opts.depth === 'full') {
+    // -1 asks the gateway for the full history.
+    body.depth = -1;
+  } else if (opts.depth) {
+    const depth = Number(opts.depth);
+    if (!Number.isInteger(depth) || depth < 1) throw new Error("--depth must be a positive number or full");
+    body.depth = depth;
+  }
+  if (opts.sparse.length) body.sparse = opts.sparse;
+  if (opts.token) body.credentials = { username: opts.username, token: opts.token };
+  return body;
 }
 
 
@@ -262,17 +472,37 @@ function clearCmdLine() {
   view.textContent = "";
 }
 
-function addLine(text, style, time) {
+const htmlEscapes = {
+  "&": "&amp;",
+  "<": "&lt;",
+  ">": "&gt;",
+  '"': "&quot;",
+  "'": "&#39;",
+};
+
+// formatLine turns text into HTML for a history line. Lines can carry
+// server messages, so text is escaped and never parsed as markup.
+function formatLine(text) {
   let t = "";
 
   for (let i = 0; i < text.length; i++) {
-    if (text.charAt(i) === " " && text.charAt(i + 1) === " ") {
+    const c = text.charAt(i);
+    if (c === " " && text.charAt(i + 1) === " ") {
       t += "&nbsp;&nbsp;";
       i++;
     } else {
-      t += text.charAt(i);
+      t += htmlEscapes[c] ?? c;
     }
   }
+  return t;
+}
+
+function setLineText(line, text) {
+  line.innerHTML = formatLine(text);
+}
+
+function addLine(text, style, time) {
+  const t = formatLine(text);
 
   return new Promise((resolve) => {
     setTimeout(() => {